@@ -0,0 +1,28 @@
+// vcs-torture/gsos/process_unix.go
+// -- Unix process groups
+
+//go:build linux || darwin
+// +build linux darwin
+
+package gsos
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// SetProcessGroup makes c start in its own process group, so that it
+// and everything it spawns can be killed together
+func SetProcessGroup(c *exec.Cmd) {
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.Setpgid = true
+}
+
+// KillProcessGroup kills p and every process in its process group
+// (p must have been started with SetProcessGroup)
+func KillProcessGroup(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
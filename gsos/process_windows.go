@@ -0,0 +1,27 @@
+// vcs-torture/gsos/process_windows.go
+// -- Windows process groups
+
+//go:build windows
+// +build windows
+
+package gsos
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// SetProcessGroup makes c start in its own process group
+func SetProcessGroup(c *exec.Cmd) {
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+// KillProcessGroup kills p. TBD Windows needs a job object to kill
+// the processes p started as well.
+func KillProcessGroup(p *os.Process) error {
+	return p.Kill()
+}
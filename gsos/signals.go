@@ -5,37 +5,48 @@ package gsos
 import (
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 )
 
 // Catch signals so that user can't accidentally interrupt something
 // sensitive (like writing a log file) - e.g. clean abort
 // For now, just catches SIGINT (ctrl-c)
+// Long-running loops should poll Aborted between steps and wind
+// down cleanly when it becomes true.
 type CatchSignals struct {
-	Abort bool
+	abort  int32
+	cancel int32
 
-	sigs   chan os.Signal
-	cancel bool
+	sigs chan os.Signal
 }
 
 // Start capturing signals
 func (s *CatchSignals) Capture() {
 	s.sigs = make(chan os.Signal, 1)
-	s.Abort = false
-	s.cancel = false
+	atomic.StoreInt32(&s.abort, 0)
+	atomic.StoreInt32(&s.cancel, 0)
 
 	signal.Notify(s.sigs, syscall.SIGINT, syscall.SIGTERM)
 	go func(s *CatchSignals) {
-		_ = <-s.sigs // change this if we care about which signal
-		if !s.cancel {
-			s.Abort = true
+		for range s.sigs { // change this if we care about which signal
+			if atomic.LoadInt32(&s.cancel) == 0 {
+				atomic.StoreInt32(&s.abort, 1)
+			}
 		}
 	}(s)
 }
 
+// Aborted returns true once a signal has been caught. It is
+// safe to call on a nil CatchSignals (which is never aborted).
+func (s *CatchSignals) Aborted() bool {
+	return s != nil && atomic.LoadInt32(&s.abort) != 0
+}
+
 // Stop capturing signals
 func (s *CatchSignals) Release() {
-	s.cancel = true
+	atomic.StoreInt32(&s.cancel, 1)
+	signal.Stop(s.sigs)
 	signal.Reset(syscall.SIGINT, syscall.SIGTERM)
 	close(s.sigs)
 }
@@ -0,0 +1,49 @@
+// vcs-torture/gsos/signals_test.go
+
+package gsos
+
+import (
+	"testing"
+
+	"os"
+	"time"
+)
+
+// interrupt sends ctrl-c to this process and waits for s to see it
+func interrupt(t *testing.T, s *CatchSignals) {
+	p, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		err = p.Signal(os.Interrupt)
+	}
+	if err != nil {
+		t.Skipf("Can't interrupt this process: %s", err)
+	}
+	for i := 0; i < 100 && !s.Aborted(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCatchSignals(t *testing.T) {
+	var none *CatchSignals
+	if none.Aborted() {
+		t.Errorf("nil CatchSignals is aborted")
+	}
+
+	var s CatchSignals
+	s.Capture()
+	if s.Aborted() {
+		t.Errorf("aborted before a signal")
+	}
+	interrupt(t, &s)
+	if !s.Aborted() {
+		t.Errorf("not aborted after a signal")
+	}
+	s.Release()
+
+	// Capturing again starts afresh
+	s.Capture()
+	if s.Aborted() {
+		t.Errorf("still aborted after capturing again")
+	}
+	s.Release()
+}
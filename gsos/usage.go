@@ -0,0 +1,36 @@
+// vcs-torture/gsos/usage.go
+
+package gsos
+
+import (
+	"os"
+)
+
+// ProcessUsage is the resources used by a child process, including
+// any children it waited for. Values that can't be measured on this
+// operating system are -1.
+type ProcessUsage struct {
+	UserTime   float64 // seconds of user CPU
+	SystemTime float64 // seconds of system CPU
+	MaxRSS     int64   // peak resident set size, in bytes
+
+	// Storage I/O (bytes that actually hit the disk)
+	ReadBytes  int64
+	WriteBytes int64
+
+	// All I/O (bytes passed to read and write calls, cached or not)
+	ReadChars  int64
+	WriteChars int64
+}
+
+// ChildUsage returns the resources used by a process that has been
+// waited for; io is what WaitExited returned for the same process.
+func ChildUsage(ps *os.ProcessState, io ProcessUsage) ProcessUsage {
+	io.UserTime = ps.UserTime().Seconds()
+	io.SystemTime = ps.SystemTime().Seconds()
+	io.MaxRSS = maxRSS(ps)
+	return io
+}
+
+// noIO is returned by WaitExited where I/O accounting isn't available
+var noIO = ProcessUsage{ReadBytes: -1, WriteBytes: -1, ReadChars: -1, WriteChars: -1}
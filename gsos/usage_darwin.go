@@ -0,0 +1,43 @@
+// vcs-torture/gsos/usage_darwin.go
+// -- Mac OS X-specific resource accounting
+
+//go:build darwin
+// +build darwin
+
+package gsos
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// WaitExited would wait for p to exit without reaping it; Mac OS X has
+// no per-process I/O counters, so there is nothing to wait for.
+func WaitExited(p *os.Process) ProcessUsage {
+	return noIO
+}
+
+// maxRSS returns peak RSS in bytes (Mac OS X reports bytes)
+func maxRSS(ps *os.ProcessState) int64 {
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		return ru.Maxrss
+	}
+	return -1
+}
+
+// LimitMemory isn't possible on Mac OS X, which doesn't enforce
+// RLIMIT_DATA.
+func LimitMemory(c *exec.Cmd, limit int64) error {
+	return CanLimitMemory()
+}
+
+// CanLimitMemory says why LimitMemory won't work
+func CanLimitMemory() error {
+	return errors.New("memory limits are not supported on Mac OS X")
+}
+
+// ExecLimited has nothing to do on Mac OS X (see LimitMemory)
+func ExecLimited() {
+}
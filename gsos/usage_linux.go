@@ -0,0 +1,129 @@
+// vcs-torture/gsos/usage_linux.go
+// -- Linux-specific resource accounting
+
+//go:build linux
+// +build linux
+
+package gsos
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// WaitExited blocks until p exits but leaves it un-reaped, so that its
+// I/O counters in /proc/<pid>/io can still be read; the caller must
+// then reap it as usual (e.g. with exec.Cmd.Wait). The counters include
+// every child the process waited for.
+func WaitExited(p *os.Process) ProcessUsage {
+	const pPID = 1 // idtype_t P_PID
+	var siginfo [128]byte
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(p.Pid),
+			uintptr(unsafe.Pointer(&siginfo[0])), syscall.WEXITED|syscall.WNOWAIT, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return noIO
+		}
+		break
+	}
+
+	usage, err := readProcIO(p.Pid)
+	if err != nil {
+		return noIO
+	}
+	return usage
+}
+
+// readProcIO parses /proc/<pid>/io
+func readProcIO(pid int) (ProcessUsage, error) {
+	usage := noIO
+	f, err := os.Open(fmt.Sprintf("/proc/%d/io", pid))
+	if err != nil {
+		return usage, err
+	}
+	defer f.Close()
+
+	fs := bufio.NewScanner(f)
+	for fs.Scan() {
+		fields := strings.SplitN(fs.Text(), ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "rchar":
+			usage.ReadChars = n
+		case "wchar":
+			usage.WriteChars = n
+		case "read_bytes":
+			usage.ReadBytes = n
+		case "write_bytes":
+			usage.WriteBytes = n
+		}
+	}
+	return usage, fs.Err()
+}
+
+// maxRSS returns peak RSS in bytes (Linux reports kilobytes)
+func maxRSS(ps *os.ProcessState) int64 {
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		return ru.Maxrss * 1024
+	}
+	return -1
+}
+
+// limitedExecArg is how ExecLimited knows it was started by LimitMemory
+const limitedExecArg = "--gsos-exec-limited"
+
+// LimitMemory makes c run with its data segment (heap and private
+// mappings) capped at limit bytes; allocations beyond that fail, and
+// children it starts inherit the limit. Setting the limit on a process
+// that is already running would leave whatever it did first unlimited,
+// so instead c runs this program, which sets the limit on itself and
+// then execs what c was going to run (see ExecLimited). Call this
+// before starting c.
+func LimitMemory(c *exec.Cmd, limit int64) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	c.Args = append([]string{self, limitedExecArg, strconv.FormatInt(limit, 10), c.Path}, c.Args...)
+	c.Path = self
+	return nil
+}
+
+// CanLimitMemory returns nil: LimitMemory works on Linux
+func CanLimitMemory() error {
+	return nil
+}
+
+// ExecLimited does the rest of LimitMemory: if this process was started
+// by it, it sets the limit and execs the real command, never returning.
+// Call it first thing in main.
+func ExecLimited() {
+	if len(os.Args) < 5 || os.Args[1] != limitedExecArg {
+		return
+	}
+	limit, err := strconv.ParseInt(os.Args[2], 10, 64)
+	if err == nil {
+		rlim := syscall.Rlimit{Cur: uint64(limit), Max: uint64(limit)}
+		err = syscall.Setrlimit(syscall.RLIMIT_DATA, &rlim)
+	}
+	if err == nil {
+		err = syscall.Exec(os.Args[3], os.Args[4:], os.Environ())
+	}
+	fmt.Fprintf(os.Stderr, "couldn't run %s with a memory limit: %s\n", os.Args[3], err)
+	os.Exit(127)
+}
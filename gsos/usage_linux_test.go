@@ -0,0 +1,49 @@
+// vcs-torture/gsos/usage_linux_test.go
+
+//go:build linux
+// +build linux
+
+package gsos
+
+import (
+	"testing"
+
+	"os/exec"
+)
+
+// TestChildUsage runs a command that reads and writes a known amount,
+// and makes sure that its CPU, memory and I/O are all accounted for
+func TestChildUsage(t *testing.T) {
+	const size = 1 << 20
+	c := exec.Command("dd", "if=/dev/zero", "of=/dev/null", "bs=65536", "count=16")
+	if err := c.Start(); err != nil {
+		t.Skipf("Can't run dd: %s", err)
+	}
+	io := WaitExited(c.Process)
+	if err := c.Wait(); err != nil {
+		t.Fatalf("dd failed: %s", err)
+	}
+	usage := ChildUsage(c.ProcessState, io)
+
+	if usage.ReadChars < size || usage.WriteChars < size {
+		t.Errorf("dd read %d and wrote %d bytes, expected at least %d", usage.ReadChars, usage.WriteChars, size)
+	}
+	if usage.ReadBytes < 0 || usage.WriteBytes < 0 {
+		t.Errorf("storage I/O wasn't measured: %+v", usage)
+	}
+	if usage.MaxRSS <= 0 || usage.UserTime < 0 || usage.SystemTime < 0 {
+		t.Errorf("CPU and memory weren't measured: %+v", usage)
+	}
+}
+
+// TestWaitExitedReaped makes sure that a process that can't be waited
+// for gives no I/O counts rather than wrong ones
+func TestWaitExitedReaped(t *testing.T) {
+	c := exec.Command("true")
+	if err := c.Run(); err != nil {
+		t.Skipf("Can't run true: %s", err)
+	}
+	if io := WaitExited(c.Process); io != noIO {
+		t.Errorf("WaitExited on a reaped process = %+v, expected %+v", io, noIO)
+	}
+}
@@ -0,0 +1,38 @@
+// vcs-torture/gsos/usage_windows.go
+// -- Windows-specific resource accounting
+
+//go:build windows
+// +build windows
+
+package gsos
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// WaitExited would wait for p to exit without reaping it; I/O counters
+// aren't collected on Windows yet, so there is nothing to wait for.
+func WaitExited(p *os.Process) ProcessUsage {
+	return noIO
+}
+
+// maxRSS isn't available from the Windows process state
+func maxRSS(ps *os.ProcessState) int64 {
+	return -1
+}
+
+// LimitMemory isn't supported on Windows yet (it needs a job object)
+func LimitMemory(c *exec.Cmd, limit int64) error {
+	return CanLimitMemory()
+}
+
+// CanLimitMemory says why LimitMemory won't work
+func CanLimitMemory() error {
+	return errors.New("memory limits are not supported on Windows")
+}
+
+// ExecLimited has nothing to do on Windows (see LimitMemory)
+func ExecLimited() {
+}
@@ -8,6 +8,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -22,17 +23,32 @@ import (
 // main parses all the command-line arguments, running in
 // groups, one group for each distinct command
 func main() {
+	// If we were started to run a command under a memory limit, do that
+	gsos.ExecLimited()
+
 	programStartTime = time.Now()
 	//fmt.Printf("Terminal width: %d\n", gsos.TerminalWidth())
 
-	cmd := &Command{args: os.Args[1:]}
+	cmd := &Command{args: os.Args[1:], numBranches: 10, numCheckouts: 10}
 	cmd.startTime = programStartTime
 
+	// Ctrl-C stops a run cleanly rather than killing it
+	cmd.signals = &gsos.CatchSignals{}
+	cmd.signals.Capture()
+
 	for len(cmd.args) > 0 {
 		cmd.Op = ""
 		cmd.args = cmd.parse()
+		if cmd.signals.Aborted() {
+			cmd.interrupted(fmt.Sprintf("Interrupted before --op=%s", cmd.Op))
+		}
 		cmd.Run()
 	}
+
+	cmd.signals.Release()
+	if err := cmd.results.Close(); err != nil {
+		log.Fatalf("Couldn't close results: %s\n", err)
+	}
 }
 
 // ----------------------------------------------------------------------------------------------
@@ -46,6 +62,8 @@ func (cmd *Command) Run() {
 		fmt.Printf("op=%s\n", cmd.Op)
 	}
 
+	vcs.SetCommandLimits(cmd.limits)
+
 	switch cmd.Op {
 	case "create":
 		cmd.OpCreate()
@@ -53,8 +71,20 @@ func (cmd *Command) Run() {
 		cmd.OpRemove()
 	case "worktree":
 		cmd.OpWorktree()
+	case "verify":
+		cmd.OpVerify()
+	case "profile":
+		cmd.OpProfile()
 	case "commit":
 		cmd.OpCommit()
+	case "branch":
+		cmd.OpBranch()
+	case "checkout":
+		cmd.OpCheckout()
+	case "clone":
+		cmd.OpClone()
+	case "network":
+		cmd.OpNetwork()
 	default:
 		log.Fatalf("Unknown op: %s\n", cmd.Op)
 	}
@@ -72,9 +102,83 @@ func (cmd *Command) mustHaveRepo() {
 	}
 }
 
+// getResults returns the results stream, opening it on first use;
+// if --results wasn't given, this is nil and results are discarded
+func (cmd *Command) getResults() *vcs.Results {
+	if cmd.resultsPath == "" || cmd.results != nil {
+		return cmd.results
+	}
+
+	if cmd.runID == "" {
+		cmd.runID = cmd.startTime.Format("20060102-150405")
+	}
+	results, err := vcs.OpenResults(cmd.resultsPath, cmd.runID)
+	if err != nil {
+		log.Fatalf("Couldn't open results file: %s\n", err)
+	}
+	cmd.results = results
+	return cmd.results
+}
+
+// interrupted reports how far we got after a ctrl-c, and exits
+func (cmd *Command) interrupted(progress string) {
+	fmt.Fprintf(os.Stderr, "\n%s\n", progress)
+	if cmd.results != nil {
+		fmt.Fprintf(os.Stderr, "Results saved in %s\n", cmd.resultsPath)
+	}
+	cmd.results.Close()
+	os.Exit(130)
+}
+
+// fatalf closes the results file (so that everything up to the
+// failure is kept) and then exits
+func (cmd *Command) fatalf(format string, v ...interface{}) {
+	cmd.results.Close()
+	log.Fatalf(format, v...)
+}
+
+// getProfile returns the --profile profile, loading it on first use;
+// without --profile, it's nil
+func (cmd *Command) getProfile() *vcs.Profile {
+	if cmd.profilePath == "" {
+		return nil
+	}
+	if cmd.profile == nil || cmd.profile.String() != cmd.profilePath {
+		profile, err := vcs.LoadProfile(cmd.profilePath)
+		if err != nil {
+			log.Fatalf("Couldn't load profile: %s\n", err)
+		}
+		cmd.profile = profile
+	}
+	return cmd.profile
+}
+
+// worktreeOptions are the worktree options from the command line. With
+// a profile, the number of files is the profile's times --profile-scale,
+// unless --worktree-file-count says otherwise.
+func (cmd *Command) worktreeOptions() vcs.WorktreeOptions {
+	wopt := vcs.WorktreeOptions{NumFiles: cmd.numFiles, FilesPerDir: cmd.filesPerDir, DirsPerDir: cmd.dirsPerDir, FileSize: cmd.fileSize,
+		Jobs: cmd.jobs, Seed: int64(cmd.seed), Content: cmd.content, Sizes: cmd.sizes, Layout: cmd.layout,
+		Profile: cmd.getProfile()}
+	if wopt.Profile != nil {
+		if wopt.Layout != vcs.LayoutBalanced {
+			log.Fatalf("Use either --layout or --profile, not both")
+		}
+		if wopt.NumFiles == 0 {
+			scale := cmd.profileScale
+			if scale == 0 {
+				scale = 1
+			}
+			wopt.NumFiles = int(float64(wopt.Profile.Files)*scale + 0.5)
+		}
+	}
+	return wopt
+}
+
 func (cmd *Command) mustHaveVcs() {
 	if cmd.Vcs == "" {
-		log.Fatalf("Specify version control system with --vcs")
+		log.Fatalf("Specify version control system with --vcs (one of: %s)",
+			strings.Join(vcs.BackendNames(), ", "))
 	}
 }
 
@@ -85,16 +189,34 @@ func (cmd *Command) OpCreate() {
 	cmd.mustHaveRepo()
 	cmd.mustHaveVcs()
 
-	repo := vcs.NewRepo(cmd.Dest, cmd.Repo, cmd.Vcs, cmd.startTime, vcs.RepoOptions{})
+	repo := vcs.NewRepo(cmd.Dest, cmd.Repo, cmd.Vcs, cmd.startTime, vcs.RepoOptions{OnError: cmd.onError, Retries: cmd.retries})
 	repo.SetVerbose(cmd.Verbose)
-	if !repo.Create() {
-		log.Fatalf("Couldn't create repo\n")
+	repo.SetResults(cmd.getResults())
+	if err := repo.Create(); err != nil {
+		cmd.fatalf("Couldn't create repo: %s\n", err)
+	}
+	if cmd.signals.Aborted() {
+		cmd.interrupted("Interrupted after creating " + repo.GetRepo())
 	}
 }
 
 func (cmd *Command) OpRemove() {
-	if !vcs.DeleteRepo(cmd.Dest, cmd.Repo, cmd.Vcs) {
-		log.Fatalf("Couldn't remove repo\n")
+	cmd.mustHaveDest()
+	cmd.mustHaveRepo()
+
+	opts := vcs.RemoveOptions{Force: cmd.force, DryRun: cmd.dryRun}
+	paths, err := vcs.DeleteRepo(cmd.Dest, cmd.Repo, cmd.Vcs, opts)
+	if err != nil {
+		log.Fatalf("Couldn't remove repo: %s\n", err)
+	}
+	if cmd.dryRun || cmd.Verbose {
+		verb := "Removed"
+		if cmd.dryRun {
+			verb = "Would remove"
+		}
+		for _, path := range paths {
+			fmt.Printf("%s %s\n", verb, path)
+		}
 	}
 }
 
@@ -102,9 +224,10 @@ func (cmd *Command) OpWorktree() {
 	cmd.mustHaveDest()
 	cmd.mustHaveRepo()
 
-	wopt := vcs.WorktreeOptions{NumFiles: cmd.numFiles, FilesPerDir: cmd.filesPerDir, DirsPerDir: cmd.dirsPerDir, FileSize: cmd.fileSize }
+	wopt := cmd.worktreeOptions()
 	w := vcs.NewWorktree(cmd.Dest, cmd.Repo, wopt)
 	w.SetVerbose(cmd.Verbose)
+	w.SetSignals(cmd.signals)
 
 	cstatus := NewConsoleStatus().Throttle(100*time.Millisecond)
 	fn := func(cb *vcs.WorktreeCallbackData) bool {
@@ -113,8 +236,67 @@ func (cmd *Command) OpWorktree() {
 	}
 
 	if !w.Generate(fn) {
+		if cmd.signals.Aborted() {
+			cmd.interrupted(fmt.Sprintf("Interrupted after creating %d of %d worktree files", len(w.Files), w.NumFiles))
+		}
 		log.Fatalf("Couldn't put files in worktree\n")
 	}
+	fmt.Fprintf(os.Stderr, "\nWorktree has %d files, %d bytes (%d bytes written)\n", len(w.Files), w.TotalBytes(), w.Written)
+}
+
+// OpProfile scans a directory tree and saves its profile
+func (cmd *Command) OpProfile() {
+	if cmd.scanDir == "" {
+		log.Fatalf("Specify directory to profile with --scan")
+	}
+	if cmd.profilePath == "" {
+		log.Fatalf("Specify file to save profile in with --profile")
+	}
+
+	profile, err := vcs.ScanProfile(cmd.scanDir)
+	if err != nil {
+		log.Fatalf("Couldn't profile %s: %s\n", cmd.scanDir, err)
+	}
+	if err := profile.Save(cmd.profilePath); err != nil {
+		log.Fatalf("Couldn't save profile: %s\n", err)
+	}
+	cmd.profile = nil
+	fmt.Printf("%s", profile.Summary())
+}
+
+// OpVerify checks the worktree against its manifest
+func (cmd *Command) OpVerify() {
+	cmd.mustHaveDest()
+	cmd.mustHaveRepo()
+
+	repo := vcs.NewRepo(cmd.Dest, cmd.Repo, cmd.Vcs, cmd.startTime, vcs.RepoOptions{})
+	repo.SetVerbose(cmd.Verbose)
+	repo.SetSignals(cmd.signals)
+	wopt := cmd.worktreeOptions()
+	repo.AddWorktree(wopt)
+
+	cstatus := NewConsoleStatus().Throttle(100*time.Millisecond)
+	fn := func(cb *vcs.WorktreeCallbackData) bool {
+	    return (cstatus.Ready() || cb.Done) && cstatus.Output(
+	        fmt.Sprintf("verify %d/%d files: %s", cb.Pos, cb.NumFiles, cb.Path))
+	}
+
+	stats, err := repo.VerifyWorktree(fn)
+	if err != nil {
+		if errors.Is(err, vcs.ErrAborted) {
+			cmd.interrupted("Verify " + err.Error())
+		}
+		cmd.fatalf("\nFailed verify: %s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "\n")
+	for _, problem := range stats.Problems {
+		fmt.Printf("%s\n", problem)
+	}
+	fmt.Printf("Verified %d files (%d deleted): %d missing, %d wrong size, %d wrong content\n",
+		stats.Files, stats.Deleted, stats.Missing, stats.WrongSize, stats.WrongHash)
+	if stats.Bad() > 0 {
+		cmd.fatalf("Worktree doesn't match its manifest\n")
+	}
 }
 
 func (cmd *Command) OpCommit() {
@@ -122,11 +304,18 @@ func (cmd *Command) OpCommit() {
 	cmd.mustHaveRepo()
 	cmd.mustHaveVcs()
 
-	ropt := vcs.RepoOptions{NumCommits: cmd.numCommits, AddsPerCommit: cmd.addsPerCommit, FilesPerAdd: cmd.filesPerAdd}
+	ropt := vcs.RepoOptions{NumCommits: cmd.numCommits, AddsPerCommit: cmd.addsPerCommit, FilesPerAdd: cmd.filesPerAdd,
+		OnError: cmd.onError, Retries: cmd.retries, Resume: cmd.resume, SampleEvery: cmd.sampleEvery,
+		Calibrate: cmd.calibrate, ModifyPercent: cmd.modifyPercent, EditStyles: cmd.editStyles,
+		EditLines: cmd.editLines, RewriteFraction: cmd.rewriteFraction,
+		DeleteRate: cmd.deleteRate, RenameRate: cmd.renameRate, DirMoveRate: cmd.dirMoveRate,
+		Seed: int64(cmd.seed)}
 	repo := vcs.NewRepo(cmd.Dest, cmd.Repo, cmd.Vcs, cmd.startTime, ropt)
 	repo.SetVerbose(cmd.Verbose)
+	repo.SetResults(cmd.getResults())
+	repo.SetSignals(cmd.signals)
 
-	wopt := vcs.WorktreeOptions{NumFiles: cmd.numFiles, FilesPerDir: cmd.filesPerDir, DirsPerDir: cmd.dirsPerDir, FileSize: cmd.fileSize }
+	wopt := cmd.worktreeOptions()
 	repo.AddWorktree(wopt)
 
 	// Make sure we have enough files in the worktree
@@ -138,6 +327,9 @@ func (cmd *Command) OpCommit() {
 	}
 
 	if !repo.Worktree.Generate(wfn) {
+		if cmd.signals.Aborted() {
+			cmd.interrupted(fmt.Sprintf("Interrupted after creating %d of %d worktree files", len(repo.Worktree.Files), repo.Worktree.NumFiles))
+		}
 		log.Fatalf("Couldn't put files in worktree\n")
 	}
 
@@ -149,9 +341,110 @@ func (cmd *Command) OpCommit() {
 			cb.Commit, cmd.numCommits, cb.NumIndexFiles, cb.LooseObjects, cb.PackObjects))
 	}
 
-	if !repo.Commit(fn) {
-		log.Fatalf("Failed commit\n")
+	if err := repo.Commit(fn); err != nil {
+		if errors.Is(err, vcs.ErrAborted) {
+			cmd.interrupted("Commit run " + err.Error())
+		}
+		cmd.fatalf("\nFailed commit: %s\n", err)
+	}
+}
+
+// historyRepo sets up an existing repo for a branch or checkout run
+func (cmd *Command) historyRepo() *vcs.Repo {
+	cmd.mustHaveDest()
+	cmd.mustHaveRepo()
+	cmd.mustHaveVcs()
+
+	ropt := vcs.RepoOptions{OnError: cmd.onError, Retries: cmd.retries, Calibrate: cmd.calibrate,
+		NumBranches: cmd.numBranches, BranchEvery: cmd.branchEvery, NamedBranches: cmd.namedBranches,
+		NumCheckouts: cmd.numCheckouts, CheckoutDistance: cmd.checkoutDistance}
+	repo := vcs.NewRepo(cmd.Dest, cmd.Repo, cmd.Vcs, cmd.startTime, ropt)
+	repo.SetVerbose(cmd.Verbose)
+	repo.SetResults(cmd.getResults())
+	repo.SetSignals(cmd.signals)
+	return repo
+}
+
+func (cmd *Command) OpBranch() {
+	repo := cmd.historyRepo()
+
+	cstatus := NewConsoleStatus().Throttle(50 * time.Millisecond)
+	fn := func(cb *vcs.BranchCallbackData) bool {
+		return cstatus.Ready() && cstatus.Output(
+			fmt.Sprintf("branch %d/%d: %s", cb.Pos, cb.Total, cb.Branch))
+	}
+
+	if err := repo.Branch(fn); err != nil {
+		if errors.Is(err, vcs.ErrAborted) {
+			cmd.interrupted("Branch run " + err.Error())
+		}
+		cmd.fatalf("\nFailed branch: %s\n", err)
+	}
+}
+
+func (cmd *Command) OpCheckout() {
+	repo := cmd.historyRepo()
+
+	cstatus := NewConsoleStatus().Throttle(50 * time.Millisecond)
+	fn := func(cb *vcs.CheckoutCallbackData) bool {
+		return cstatus.Ready() && cstatus.Output(
+			fmt.Sprintf("checkout %d/%d: commit %d -> %d (%d paths)", cb.Pos, cb.Total, cb.From, cb.To, cb.DiffFiles))
+	}
+
+	if err := repo.Checkout(fn); err != nil {
+		if errors.Is(err, vcs.ErrAborted) {
+			cmd.interrupted("Checkout run " + err.Error())
+		}
+		cmd.fatalf("\nFailed checkout: %s\n", err)
+	}
+}
+
+func (cmd *Command) OpClone() {
+	cmd.mustHaveDest()
+	cmd.mustHaveRepo()
+	cmd.mustHaveVcs()
+
+	ropt := vcs.RepoOptions{OnError: cmd.onError, Retries: cmd.retries, Calibrate: cmd.calibrate,
+		CloneMode: cmd.cloneMode, CloneDepth: cmd.cloneDepth}
+	repo := vcs.NewRepo(cmd.Dest, cmd.Repo, cmd.Vcs, cmd.startTime, ropt)
+	repo.SetVerbose(cmd.Verbose)
+	repo.SetResults(cmd.getResults())
+	repo.SetSignals(cmd.signals)
+
+	stats, err := repo.Clone()
+	if err != nil {
+		if errors.Is(err, vcs.ErrAborted) {
+			cmd.interrupted("Clone " + err.Error())
+		}
+		cmd.fatalf("Failed clone: %s\n", err)
 	}
+	fmt.Printf("Cloned %s (%s) to %s: clone %.3fs, checkout %.3fs, %d bytes in %d files\n",
+		repo.GetRepo(), stats.Mode, stats.Dir, stats.CloneTime, stats.CheckoutTime, stats.DiskBytes, stats.DiskFiles)
+}
+
+func (cmd *Command) OpNetwork() {
+	cmd.mustHaveDest()
+	cmd.mustHaveRepo()
+	cmd.mustHaveVcs()
+
+	ropt := vcs.RepoOptions{OnError: cmd.onError, Retries: cmd.retries, Calibrate: cmd.calibrate,
+		Protocol: cmd.protocol, PushFiles: cmd.pushFiles, PushFileSize: cmd.fileSize,
+		Impairment: cmd.impairment, Proxy: cmd.proxy, Seed: int64(cmd.seed)}
+	ropt.Impairment.ChunkSize = int(cmd.chunkSize)
+	repo := vcs.NewRepo(cmd.Dest, cmd.Repo, cmd.Vcs, cmd.startTime, ropt)
+	repo.SetVerbose(cmd.Verbose)
+	repo.SetResults(cmd.getResults())
+	repo.SetSignals(cmd.signals)
+
+	stats, err := repo.Network()
+	if err != nil {
+		if errors.Is(err, vcs.ErrAborted) {
+			cmd.interrupted("Network run " + err.Error())
+		}
+		cmd.fatalf("Failed network run: %s\n", err)
+	}
+	fmt.Printf("Network (%s, %s): clone %.3fs, push %.3fs, fetch %.3fs\n",
+		stats.Protocol, stats.URL, stats.CloneTime, stats.PushTime, stats.FetchTime)
 }
 
 // ----------------------------------------------------------------------------------------------
@@ -173,17 +466,83 @@ type Command struct {
 	numFiles    int
 	filesPerDir int
 	dirsPerDir  int
-	fileSize    int
+	fileSize    int64
+	seed        int
+	jobs        int
+
+	contentNames string
+	content      vcs.ContentMix
+	sizeDist     string
+	sizes        *vcs.SizeDist
+	layout       string
+
+	// profile params
+	profilePath  string
+	profileScale float64
+	scanDir      string
+	profile      *vcs.Profile
 
 	// commit params
 	numCommits int
 	addsPerCommit int
 	filesPerAdd int
+	resume bool
+	sampleEvery int
+	calibrate int
+
+	// edits to committed files
+	modifyPercent   float64
+	editStyleNames  string
+	editStyles      []string
+	editLines       int
+	rewriteFraction float64
+	deleteRate      float64
+	renameRate      float64
+	dirMoveRate     float64
+
+	// branch and checkout params
+	numBranches      int
+	branchEvery      int
+	namedBranches    bool
+	numCheckouts     int
+	checkoutDistance int
+
+	// clone params
+	cloneMode  string
+	cloneDepth int
+
+	// network params
+	protocol  string
+	pushFiles int
+
+	// simulated network (see vcs.Impairment)
+	impairment vcs.Impairment
+	chunkSize  int64
+	proxy      bool
+
+	// what to do when a version control command fails
+	onErrorName string
+	onError     vcs.ErrorPolicy
+	retries     int
+
+	// limits on each version control command
+	limits vcs.CommandLimits
+
+	// results output (NDJSON, one record per timed operation)
+	resultsPath string
+	runID       string
+	results     *vcs.Results
 
 	Help    bool
 	Verbose bool
 	Abort   bool
 
+	// remove params
+	force  bool
+	dryRun bool
+
+	signals *gsos.CatchSignals
+
 	// remaining command-line arguments
 	args []string
 
@@ -193,7 +552,27 @@ type Command struct {
 
 func usage(fail int) {
 	fmt.Printf("Usage: vcs=torture [--vcs=<vcs-name>]\n" +
+		"            [--results=<file>] [--run-id=<id>] [--resume]\n" +
+		"            [--force] [--dry-run] [--sample-every=<commits>]\n" +
+		"            [--calibrate=<runs>] [--seed=<n>] [--jobs=<n>]\n" +
+		"            [--content=<kind>[:<pct>][,<kind>:<pct>...]]\n" +
+		"            [--size-dist=uniform:<min>-<max>|lognormal:<median>:<sigma>[:<max>]|\n" +
+		"                histogram:<min>-<max>:<pct>[,<min>-<max>:<pct>...]]\n" +
+		"            [--layout=balanced|flat|deep|zipf|monorepo]\n" +
+		"            [--scan=<dir>] [--profile=<file>] [--profile-scale=<x>]\n" +
+		"            [--modify-percent=<pct>] [--edit-style=<style>[,<style>...]|all]\n" +
+		"            [--edit-lines=<n>] [--rewrite-fraction=<fraction>]\n" +
+		"            [--delete-rate=<n>] [--rename-rate=<n>] [--dir-move-rate=<n>]\n" +
+		"            [--num-branches=<n>] [--branch-every=<commits>] [--named-branches]\n" +
+		"            [--num-checkouts=<n>] [--checkout-distance=<commits>]\n" +
+		"            [--clone-mode=<mode>] [--clone-depth=<commits>]\n" +
+		"            [--protocol=<protocol>] [--push-files=<n>] [--proxy]\n" +
+		"            [--latency=<duration>] [--jitter=<duration>]\n" +
+		"            [--bandwidth=<size>] [--chunk-size=<size>]\n" +
+		"            [--on-error=abort|skip|retry] [--retries=<n>]\n" +
+		"            [--op-timeout=<duration>] [--op-memory-limit=<size>]\n" +
 		"            [-v|--verbose] [-h|--help]\n")
+	fmt.Printf("Supported version control systems: %s\n", strings.Join(vcs.BackendNames(), ", "))
 	os.Exit(fail)
 }
 
@@ -223,22 +602,66 @@ func (cmd *Command) parse() []string {
 		parseint := func(opt string, val *int) bool { return ParseIntArg(arg, opt, val) }
 		parsestr := func(opt string, val *string) bool { return ParseStrArg(arg, opt, val) }
 		parsebool := func(opt string, val *bool) bool { return ParseBoolArg(arg, opt, val) }
+		parseduration := func(opt string, val *time.Duration) bool { return ParseDurationArg(arg, opt, val) }
+		parsesize := func(opt string, val *int64) bool { return ParseSizeArg(arg, opt, val) }
+		parsefloat := func(opt string, val *float64) bool { return ParseFloatArg(arg, opt, val) }
 
 		if !parsersp() &&
 			!parsestr("--dest=", &cmd.Dest) &&
 			!parsestr("--repo=", &cmd.Repo) &&
 			!parsestr("--op=", &cmd.Op) &&
 			!parsestr("--vcs=", &cmd.Vcs) &&
+			!parsestr("--results=", &cmd.resultsPath) &&
+			!parsestr("--run-id=", &cmd.runID) &&
+			!parsestr("--on-error=", &cmd.onErrorName) &&
+			!parseint("--retries=", &cmd.retries) &&
+			!parseduration("--op-timeout=", &cmd.limits.Timeout) &&
+			!parsesize("--op-memory-limit=", &cmd.limits.Memory) &&
 
 			!parseint("--worktree-file-count=", &cmd.numFiles) &&
-			!parseint("--worktree-file-size=", &cmd.fileSize) &&
+			!parsesize("--worktree-file-size=", &cmd.fileSize) &&
 			!parseint("--files-per-dir=", &cmd.filesPerDir) &&
 			!parseint("--dirs-per-dir=", &cmd.dirsPerDir) &&
+			!parseint("--seed=", &cmd.seed) &&
+			!parseint("--jobs=", &cmd.jobs) &&
+			!parsestr("--content=", &cmd.contentNames) &&
+			!parsestr("--size-dist=", &cmd.sizeDist) &&
+			!parsestr("--layout=", &cmd.layout) &&
+			!parsestr("--profile=", &cmd.profilePath) &&
+			!parsefloat("--profile-scale=", &cmd.profileScale) &&
+			!parsestr("--scan=", &cmd.scanDir) &&
 
 			!parseint("--num-commits=", &cmd.numCommits) &&
 			!parseint("--adds-per-commit=", &cmd.addsPerCommit) &&
 			!parseint("--files-per-add=", &cmd.filesPerAdd) &&
-
+			!parseint("--sample-every=", &cmd.sampleEvery) &&
+			!parseint("--calibrate=", &cmd.calibrate) &&
+			!parsefloat("--modify-percent=", &cmd.modifyPercent) &&
+			!parsestr("--edit-style=", &cmd.editStyleNames) &&
+			!parseint("--edit-lines=", &cmd.editLines) &&
+			!parsefloat("--rewrite-fraction=", &cmd.rewriteFraction) &&
+			!parsefloat("--delete-rate=", &cmd.deleteRate) &&
+			!parsefloat("--rename-rate=", &cmd.renameRate) &&
+			!parsefloat("--dir-move-rate=", &cmd.dirMoveRate) &&
+
+			!parseint("--num-branches=", &cmd.numBranches) &&
+			!parseint("--branch-every=", &cmd.branchEvery) &&
+			!parsebool("--named-branches", &cmd.namedBranches) &&
+			!parseint("--num-checkouts=", &cmd.numCheckouts) &&
+			!parseint("--checkout-distance=", &cmd.checkoutDistance) &&
+			!parsestr("--clone-mode=", &cmd.cloneMode) &&
+			!parseint("--clone-depth=", &cmd.cloneDepth) &&
+			!parsestr("--protocol=", &cmd.protocol) &&
+			!parseint("--push-files=", &cmd.pushFiles) &&
+			!parseduration("--latency=", &cmd.impairment.Latency) &&
+			!parseduration("--jitter=", &cmd.impairment.Jitter) &&
+			!parsesize("--bandwidth=", &cmd.impairment.Bandwidth) &&
+			!parsesize("--chunk-size=", &cmd.chunkSize) &&
+			!parsebool("--proxy", &cmd.proxy) &&
+
+			!parsebool("--resume", &cmd.resume) &&
+			!parsebool("--force", &cmd.force) &&
+			!parsebool("--dry-run", &cmd.dryRun) &&
 			!parsebool("--print", &cmd.print) &&
 			!parsebool("-v", &cmd.Verbose) &&
 			!parsebool("--verbose", &cmd.Verbose) &&
@@ -248,6 +671,61 @@ func (cmd *Command) parse() []string {
 			usage(1)
 		}
 
+		if _, ok := vcs.LookupBackend(cmd.Vcs); cmd.Vcs != "" && !ok {
+			fmt.Printf("Unknown version control system '%s'\n", cmd.Vcs)
+			usage(1)
+		}
+
+		if cmd.onErrorName != "" {
+			policy, ok := vcs.ParseErrorPolicy(cmd.onErrorName)
+			if !ok {
+				fmt.Printf("Unknown error policy '%s'\n", cmd.onErrorName)
+				usage(1)
+			}
+			cmd.onError = policy
+		}
+
+		if cmd.contentNames != "" {
+			mix, err := vcs.ParseContentMix(cmd.contentNames)
+			if err != nil {
+				fmt.Printf("%s\n", err)
+				usage(1)
+			}
+			cmd.content = mix
+		}
+
+		if cmd.limits.Memory > 0 {
+			if err := gsos.CanLimitMemory(); err != nil {
+				fmt.Printf("Can't use --op-memory-limit: %s\n", err)
+				usage(1)
+			}
+		}
+
+		if cmd.sizeDist != "" {
+			sizes, err := vcs.ParseSizeDist(cmd.sizeDist)
+			if err != nil {
+				fmt.Printf("%s\n", err)
+				usage(1)
+			}
+			cmd.sizes = sizes
+		}
+
+		if layout, err := vcs.ParseLayout(cmd.layout); err != nil {
+			fmt.Printf("%s\n", err)
+			usage(1)
+		} else {
+			cmd.layout = layout
+		}
+
+		if cmd.editStyleNames != "" {
+			styles, err := vcs.ParseEditStyles(cmd.editStyleNames)
+			if err != nil {
+				fmt.Printf("%s\n", err)
+				usage(1)
+			}
+			cmd.editStyles = styles
+		}
+
 		if cmd.Op != "" {
 			break
 		}
@@ -282,6 +760,52 @@ func ParseIntArg(arg string, opt string, val *int) bool {
 	return true
 }
 
+// ParseFloatArg parses a decimal number (e.g. 2.5)
+func ParseFloatArg(arg string, opt string, val *float64) bool {
+	var strval string
+	if !ParseStrArg(arg, opt, &strval) {
+		return false
+	}
+
+	f, err := strconv.ParseFloat(strval, 64)
+	if err != nil {
+		return false
+	}
+	*val = f
+	return true
+}
+
+// ParseDurationArg parses a Go-style duration (e.g. 90s, 2h30m)
+func ParseDurationArg(arg string, opt string, val *time.Duration) bool {
+	var strval string
+	if !ParseStrArg(arg, opt, &strval) {
+		return false
+	}
+
+	d, err := time.ParseDuration(strval)
+	if err != nil {
+		return false
+	}
+	*val = d
+	return true
+}
+
+// ParseSizeArg parses a size in bytes, with an optional K, M or G
+// suffix (powers of 1024)
+func ParseSizeArg(arg string, opt string, val *int64) bool {
+	var strval string
+	if !ParseStrArg(arg, opt, &strval) {
+		return false
+	}
+
+	n, err := vcs.ParseSize(strval)
+	if err != nil {
+		return false
+	}
+	*val = n
+	return true
+}
+
 func ParseStrArg(arg string, opt string, val *string) bool {
 	optlen := len(opt)
 	if len(arg) <= optlen || arg[:optlen] != opt {
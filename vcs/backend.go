@@ -0,0 +1,167 @@
+// vcs-torture/vcs/backend.go
+
+package vcs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Backend is a version control system that can be tortured. Each
+// backend is self-contained; Repo only ever talks to a Backend, so
+// adding a new version control system means writing a new Backend
+// and registering it.
+type Backend interface {
+	// Name is the name used to select this backend (e.g. --vcs=git)
+	Name() string
+
+	// ServerDir returns the path of the server-side storage for
+	// client/server systems (e.g. the Subversion repository that
+	// a working copy is checked out from), or "" if there is none.
+	ServerDir(r *Repo) string
+
+	// Init creates a new empty repo at r.repo (which already exists).
+	Init(r *Repo) error
+
+	// Remove deletes the repo and any server-side storage.
+	Remove(r *Repo) error
+
+	// Add adds files to the repo; files will fit on a single command line.
+	Add(r *Repo, files []string) (*CmdResult, error)
+
+	// AddModified tells the repo about changes to files it already
+	// has, ready to commit; for systems that notice changes by
+	// themselves, this does nothing and returns an empty CmdResult.
+	AddModified(r *Repo, files []string) (*CmdResult, error)
+
+	// Delete removes files from the repo (and the worktree), even if
+	// they have just been changed; files will fit on a single command
+	// line.
+	Delete(r *Repo, files []string) (*CmdResult, error)
+
+	// Move renames a file or a whole directory in the repo (and the
+	// worktree).
+	Move(r *Repo, from string, to string) (*CmdResult, error)
+
+	// Status shows what has changed in the worktree; after renames and
+	// moves, this is where a system that tracks them by content (git)
+	// has to work them out.
+	Status(r *Repo) (*CmdResult, error)
+
+	// Commit commits everything that has been added.
+	Commit(r *Repo, message string) (*CmdResult, error)
+
+	// HeadFiles returns the number of files in the tip of the repo.
+	HeadFiles(r *Repo) (int, error)
+
+	// NumCommits returns the number of commits in the repo.
+	NumCommits(r *Repo) (int, error)
+
+	// VersionCommand is a trivial command (e.g. git --version) used
+	// to measure how long the version control system takes to start.
+	VersionCommand() []string
+
+	// StoreDir is the directory that holds the repo's history
+	// (e.g. .git); its size is measured for StoreStats.
+	StoreDir(r *Repo) string
+
+	// StoreStats fills in the backend-specific parts of stats.
+	StoreStats(r *Repo, stats *StoreStats) error
+
+	// Revisions returns the ids of the commits on the main line of
+	// development, oldest first, so commit n is Revisions()[n-1].
+	Revisions(r *Repo) ([]string, error)
+
+	// Branch makes a branch called name starting at revision rev,
+	// returning how long it took (it may take several commands).
+	Branch(r *Repo, name string, rev string) (float64, error)
+
+	// Branches lists the branches in the repo (all of them; callers
+	// pick out the ones they made).
+	Branches(r *Repo) ([]string, error)
+
+	// Checkout switches the worktree to the tip of branch or, if branch
+	// is "", to revision rev of the main line; with both empty, it goes
+	// back to the tip of the main line.
+	Checkout(r *Repo, branch string, rev string) (*CmdResult, error)
+
+	// DiffFiles counts the paths that differ between two revisions.
+	DiffFiles(r *Repo, from string, to string) (int, error)
+
+	// CloneModes lists the kinds of clone that Clone can make; the
+	// first is the default.
+	CloneModes() []string
+
+	// Clone copies the repo to dir (a sibling of r.repo) and fills in
+	// its worktree, timing the two separately (either may be 0 if the
+	// mode doesn't do it).
+	Clone(r *Repo, dir string, mode string) (clone float64, checkout float64, err error)
+
+	// ServeProtocols lists the protocols Serve can serve the repo
+	// over; the first is the default. "file" needs no server.
+	ServeProtocols() []string
+
+	// Serve starts a server for the repo on localhost. Its URL is what
+	// the Remote methods are given.
+	Serve(r *Repo, protocol string) (*Server, error)
+
+	// RemoteClone clones (or checks out) the main line from url to dir.
+	RemoteClone(r *Repo, url string, dir string) (*CmdResult, error)
+
+	// RemotePush commits path (inside the clone in dir) on a new branch,
+	// and sends it to url; only sending it is recorded as the push.
+	RemotePush(r *Repo, url string, dir string, branch string, path string) (*CmdResult, error)
+
+	// RemoteFetch brings a branch pushed by RemotePush from url into
+	// the clone in dir.
+	RemoteFetch(r *Repo, url string, dir string, branch string) (*CmdResult, error)
+}
+
+var backends map[string]Backend = make(map[string]Backend)
+
+// RegisterBackend makes a backend available by name. This is
+// normally called from an init function in the backend's file.
+func RegisterBackend(b Backend) {
+	backends[b.Name()] = b
+}
+
+// LookupBackend returns the backend with the given name
+func LookupBackend(name string) (Backend, bool) {
+	b, ok := backends[name]
+	return b, ok
+}
+
+// BackendNames returns the names of all registered backends, sorted
+func BackendNames() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// removeRepoDirs deletes the repo and its server directory (if any)
+func removeRepoDirs(r *Repo) error {
+	err := os.RemoveAll(r.repo)
+	if err == nil && r.serverDir != "" {
+		err = os.RemoveAll(r.serverDir)
+	}
+	return err
+}
+
+// fileURL turns a path into a file:// URL. This needs an absolute
+// path with forward slashes: file:///C:/x on Windows, and file:///x
+// (not file:////x) elsewhere.
+func fileURL(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.ToSlash(path)
+	if strings.HasPrefix(path, "/") {
+		return "file://" + path
+	}
+	return "file:///" + path
+}
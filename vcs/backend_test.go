@@ -0,0 +1,27 @@
+// vcs-torture/vcs/backend_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"strings"
+)
+
+func TestLookupBackend(t *testing.T) {
+	names := BackendNames()
+	if strings.Join(names, ",") != "git,hg,svn" {
+		t.Errorf("BackendNames() = %v, expected [git hg svn]", names)
+	}
+	for _, name := range names {
+		b, ok := LookupBackend(name)
+		if !ok || b.Name() != name {
+			t.Errorf("LookupBackend(%q) = %v, %t", name, b, ok)
+		}
+	}
+	for _, name := range []string{"", "bzr", "Git"} {
+		if b, ok := LookupBackend(name); ok {
+			t.Errorf("LookupBackend(%q) = %v, expected nothing", name, b)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// vcs-torture/vcs/branch.go
+
+package vcs
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BranchPrefix starts the name of every branch a branch run makes;
+// the rest of the name is the commit the branch starts from
+const BranchPrefix = "torture-"
+
+type BranchCallbackData struct {
+	Done   bool
+	Pos    int
+	Total  int
+	Branch string
+}
+
+type CheckoutCallbackData struct {
+	Done      bool
+	Pos       int
+	Total     int
+	From      int
+	To        int
+	DiffFiles int
+}
+
+// Branch makes r.NumBranches branches off the main line, one every
+// r.BranchEvery commits, and then goes back to the tip of the main
+// line. Branches that already exist are left alone, so a branch run
+// can be repeated after more commits.
+func (r *Repo) Branch(callback func(cb *BranchCallbackData) bool) error {
+	revs, err := r.startHistoryRun()
+	if err != nil {
+		return err
+	}
+
+	existing, err := r.ourBranches()
+	if err != nil {
+		return err
+	}
+
+	every := r.BranchEvery
+	if every <= 0 && r.NumBranches > 0 {
+		every = len(revs) / r.NumBranches
+	}
+	if every <= 0 {
+		every = 1
+	}
+
+	var cb BranchCallbackData
+	var runErr error
+	for i := 1; i <= r.NumBranches && i*every <= len(revs); i++ {
+		cb.Total++
+	}
+	for cb.Pos = 1; cb.Pos <= cb.Total; cb.Pos++ {
+		if r.aborted() {
+			runErr = ErrAborted
+			break
+		}
+
+		r.commit = cb.Pos * every
+		cb.Branch = fmt.Sprintf("%s%d", BranchPrefix, r.commit)
+		if _, ok := existing[cb.Branch]; ok {
+			continue
+		}
+		if _, err := r.backend.Branch(r, cb.Branch, revs[r.commit-1]); err != nil && r.OnError != ErrorSkip {
+			runErr = fmt.Errorf("branch %s: %s", cb.Branch, err)
+			break
+		}
+		if callback != nil && callback(&cb) {
+			break
+		}
+	}
+
+	// Making a named branch leaves the worktree on it
+	r.commit = len(revs)
+	if _, err := r.backend.Checkout(r, "", ""); err != nil && runErr == nil {
+		runErr = fmt.Errorf("returning to the main line: %s", err)
+	}
+
+	cb.Done = true
+	if callback != nil && callback(&cb) && runErr == nil {
+		runErr = ErrStopped
+	}
+	if runErr == ErrAborted {
+		runErr = fmt.Errorf("%w after %d of %d branches", ErrAborted, cb.Pos-1, cb.Total)
+	}
+	return runErr
+}
+
+// checkoutState is somewhere a checkout run goes: the tip of a
+// branch, or a commit on the main line (commit n is the tip)
+type checkoutState struct {
+	branch string
+	commit int
+}
+
+// Checkout times switching the worktree between states of the repo:
+// from the tip of the main line to each branch made by a branch run in
+// turn and back, and then r.NumCheckouts steps of r.CheckoutDistance
+// commits back through history and forward again. Each switch is
+// recorded along with how many paths differ between the two states,
+// which is what checkout time should scale with. The run always ends
+// at the tip of the main line.
+func (r *Repo) Checkout(callback func(cb *CheckoutCallbackData) bool) error {
+	revs, err := r.startHistoryRun()
+	if err != nil {
+		return err
+	}
+	n := len(revs)
+
+	// Branches in history order
+	existing, err := r.ourBranches()
+	if err != nil {
+		return err
+	}
+	var branches []checkoutState
+	for name, commit := range existing {
+		if commit <= n {
+			branches = append(branches, checkoutState{branch: name, commit: commit})
+		}
+	}
+	sort.Slice(branches, func(i, j int) bool { return branches[i].commit < branches[j].commit })
+
+	// Plan the whole run
+	tip := checkoutState{commit: n}
+	plan := branches
+	if len(branches) > 0 {
+		plan = append(plan, tip)
+	}
+	distance := r.CheckoutDistance
+	if distance <= 0 {
+		distance = 1
+	}
+	steps := 0
+	for steps < r.NumCheckouts && n-(steps+1)*distance >= 1 {
+		steps++
+		plan = append(plan, checkoutState{commit: n - steps*distance})
+	}
+	for step := steps - 1; step >= 0; step-- {
+		plan = append(plan, checkoutState{commit: n - step*distance})
+	}
+
+	var cb CheckoutCallbackData
+	var runErr error
+	cb.Total = len(plan)
+	at := tip
+	for cb.Pos = 1; cb.Pos <= cb.Total; cb.Pos++ {
+		if r.aborted() {
+			runErr = ErrAborted
+			break
+		}
+
+		to := plan[cb.Pos-1]
+		cb.From, cb.To = at.commit, to.commit
+		if err := r.checkoutTo(revs, at, to); err != nil {
+			if r.OnError != ErrorSkip {
+				runErr = fmt.Errorf("checkout %d: %s", to.commit, err)
+				break
+			}
+		} else {
+			at = to
+		}
+
+		cb.DiffFiles = r.opFiles
+		if callback != nil && callback(&cb) {
+			break
+		}
+	}
+
+	// Don't leave the worktree (or git's HEAD) somewhere odd
+	if at != tip {
+		if err := r.checkoutTo(revs, at, tip); err != nil && runErr == nil {
+			runErr = fmt.Errorf("returning to the main line: %s", err)
+		}
+	}
+
+	cb.Done = true
+	if callback != nil && callback(&cb) && runErr == nil {
+		runErr = ErrStopped
+	}
+	if runErr == ErrAborted {
+		runErr = fmt.Errorf("%w after %d of %d checkouts", ErrAborted, cb.Pos-1, cb.Total)
+	}
+	return runErr
+}
+
+// checkoutTo switches the worktree from one state to another. The
+// number of paths that differ is worked out first (and isn't timed).
+func (r *Repo) checkoutTo(revs []string, from checkoutState, to checkoutState) error {
+	diff, err := r.backend.DiffFiles(r, revs[from.commit-1], revs[to.commit-1])
+	if err != nil {
+		return err
+	}
+
+	r.fromCommit, r.commit, r.opFiles, r.branch = from.commit, to.commit, diff, to.branch
+	defer func() { r.fromCommit, r.branch = 0, "" }()
+
+	rev := ""
+	if to.branch == "" && to.commit != len(revs) {
+		rev = revs[to.commit-1]
+	}
+	_, err = r.backend.Checkout(r, to.branch, rev)
+	return err
+}
+
+// startHistoryRun gets ready for a run over an existing repo's history,
+// returning the main line revisions
+func (r *Repo) startHistoryRun() ([]string, error) {
+	if r.backend == nil {
+		return nil, fmt.Errorf("Unknown version control system: %s", r.vcs)
+	}
+	if _, err := os.Stat(r.repo); err != nil {
+		return nil, err
+	}
+	if err := r.calibrate(r.Calibrate); err != nil {
+		return nil, err
+	}
+
+	revs, err := r.backend.Revisions(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(revs) == 0 {
+		return nil, fmt.Errorf("%s has no commits", r.repo)
+	}
+	return revs, nil
+}
+
+// ourBranches returns the branches made by branch runs, and the
+// commit each one starts from
+func (r *Repo) ourBranches() (map[string]int, error) {
+	names, err := r.backend.Branches(r)
+	if err != nil {
+		return nil, err
+	}
+	branches := make(map[string]int)
+	for _, name := range names {
+		if !strings.HasPrefix(name, BranchPrefix) {
+			continue
+		}
+		if commit, err := strconv.Atoi(name[len(BranchPrefix):]); err == nil {
+			branches[name] = commit
+		}
+	}
+	return branches, nil
+}
@@ -0,0 +1,138 @@
+// vcs-torture/vcs/branch_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The fake's revisions are r1 to rN, and two revisions differ by as
+// many paths as there are commits between them
+
+func (b *fakeBackend) Revisions(r *Repo) ([]string, error) {
+	var revs []string
+	for i := 1; i <= b.commits; i++ {
+		revs = append(revs, fmt.Sprintf("r%d", i))
+	}
+	return revs, nil
+}
+
+func (b *fakeBackend) Branch(r *Repo, name string, rev string) (float64, error) {
+	b.log = append(b.log, "branch "+name+" "+rev)
+	b.branches = append(b.branches, name)
+	return 0, nil
+}
+
+func (b *fakeBackend) Branches(r *Repo) ([]string, error) {
+	return b.branches, nil
+}
+
+func (b *fakeBackend) Checkout(r *Repo, branch string, rev string) (*CmdResult, error) {
+	b.log = append(b.log, "checkout "+branch+" "+rev)
+	return &CmdResult{Exe: "fake", Params: []string{"checkout"}, Outcome: OutcomeOK}, nil
+}
+
+func (b *fakeBackend) DiffFiles(r *Repo, from string, to string) (int, error) {
+	f, _ := strconv.Atoi(from[1:])
+	t, _ := strconv.Atoi(to[1:])
+	if f > t {
+		return f - t, nil
+	}
+	return t - f, nil
+}
+
+func TestBranch(t *testing.T) {
+	dest, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	b := &fakeBackend{commits: 10, branches: []string{"master", BranchPrefix + "6"}}
+	r := NewRepo(dest, "repo", "fake", time.Now(), RepoOptions{NumBranches: 3})
+	r.backend = b
+	if err := r.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	// One every 10/3 commits, except for the one there already is, then
+	// back to the main line
+	var made []string
+	err = r.Branch(func(cb *BranchCallbackData) bool {
+		if !cb.Done {
+			made = append(made, cb.Branch)
+		}
+		return false
+	})
+	if err != nil {
+		t.Fatalf("Branch: %s", err)
+	}
+	want := "branch torture-3 r3,branch torture-9 r9,checkout  "
+	if strings.Join(b.log, ",") != want {
+		t.Errorf("Branch did %q, expected %q", b.log, want)
+	}
+	if strings.Join(made, ",") != "torture-3,torture-9" {
+		t.Errorf("Branch made %v", made)
+	}
+
+	// Far apart, there aren't as many as asked for
+	b.log = nil
+	r.NumBranches, r.BranchEvery = 5, 4
+	if err := r.Branch(nil); err != nil {
+		t.Fatalf("Branch: %s", err)
+	}
+	want = "branch torture-4 r4,branch torture-8 r8,checkout  "
+	if strings.Join(b.log, ",") != want {
+		t.Errorf("Branch every 4 did %q, expected %q", b.log, want)
+	}
+}
+
+// TestCheckout makes sure that a checkout run visits each branch, then
+// steps back through history and forward again, ending at the tip
+func TestCheckout(t *testing.T) {
+	dest, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	b := &fakeBackend{commits: 10, branches: []string{BranchPrefix + "6", "other", BranchPrefix + "3", BranchPrefix + "20"}}
+	r := NewRepo(dest, "repo", "fake", time.Now(), RepoOptions{NumCheckouts: 3, CheckoutDistance: 4})
+	r.backend = b
+	if err := r.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	var steps []string
+	err = r.Checkout(func(cb *CheckoutCallbackData) bool {
+		if !cb.Done {
+			steps = append(steps, fmt.Sprintf("%d-%d:%d", cb.From, cb.To, cb.DiffFiles))
+		}
+		return false
+	})
+	if err != nil {
+		t.Fatalf("Checkout: %s", err)
+	}
+	want := "checkout torture-3 ,checkout torture-6 ,checkout  ,checkout  r6,checkout  r2,checkout  r6,checkout  "
+	if strings.Join(b.log, ",") != want {
+		t.Errorf("Checkout did %q, expected %q", b.log, want)
+	}
+	if strings.Join(steps, ",") != "10-3:7,3-6:3,6-10:4,10-6:4,6-2:4,2-6:4,6-10:4" {
+		t.Errorf("Checkout steps were %v", steps)
+	}
+
+	// No branches and no steps back is nothing to do
+	b.log = nil
+	b.branches = nil
+	r.NumCheckouts = 0
+	if err := r.Checkout(nil); err != nil || len(b.log) != 0 {
+		t.Errorf("Checkout with nothing to do = %v, did %q", err, b.log)
+	}
+}
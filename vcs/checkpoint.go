@@ -0,0 +1,127 @@
+// vcs-torture/vcs/checkpoint.go
+
+package vcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Checkpoint records how far a commit run got, so that it can be
+// picked up again later. It lives next to the repo in dest.
+type Checkpoint struct {
+	Vcs  string `json:"vcs"`
+	Repo string `json:"repo"`
+
+	// Commits is the number of the last commit made, Files is how
+	// many worktree files have been used (the position of the next
+	// file to add)
+	Commits    int `json:"commits"`
+	Files      int `json:"files"`
+	IndexFiles int `json:"index_files"`
+
+	// Seed is the run's seed; carrying on with another would make a
+	// repo that no single seed makes
+	Seed int64 `json:"seed,omitempty"`
+
+	// Moved lists worktree files that have been renamed, moved or
+	// deleted ("") since they were committed
+	Moved map[string]string `json:"moved,omitempty"`
+
+	Time time.Time `json:"time"`
+}
+
+// checkpointPath is where the checkpoint for this repo is kept
+func (r *Repo) checkpointPath() string {
+	return r.repo + "-checkpoint.json"
+}
+
+// writeCheckpoint saves cp, replacing the previous checkpoint. It
+// writes a new file and renames it over the old one, so there is
+// always a complete checkpoint on disk even if we die part-way.
+func (r *Repo) writeCheckpoint(cp *Checkpoint) error {
+	cp.Vcs = r.vcs
+	cp.Repo = r.repoName
+	cp.Time = time.Now()
+
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := r.checkpointPath()
+	if err := recordOwned(r.dest, OwnedCheckpoint, r.vcs, path); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// checkpoint describes a run that has made committed commits and used
+// pos worktree files
+func (r *Repo) checkpoint(committed int, pos int) *Checkpoint {
+	return &Checkpoint{Commits: committed, Files: pos, IndexFiles: r.indexFiles, Seed: r.Seed, Moved: r.moved}
+}
+
+// readCheckpoint loads the checkpoint for this repo
+func (r *Repo) readCheckpoint() (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(r.checkpointPath())
+	if err != nil {
+		return nil, err
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("%s: %s", r.checkpointPath(), err)
+	}
+	return &cp, nil
+}
+
+// resumePoint works out where a resumed commit run picks up: from the
+// checkpoint if there is one, otherwise from the repo itself (this
+// assumes worktree files were committed in order, which they are
+// unless adds were skipped after errors). A checkpoint that doesn't
+// have as many commits as the repo is out of date (the run that would
+// have updated it died); it's passed over for the repo itself, unless
+// it has files that were moved or deleted, which the repo can't tell
+// us about.
+func (r *Repo) resumePoint() (*Checkpoint, error) {
+	if _, err := os.Stat(r.repo); err != nil {
+		return nil, fmt.Errorf("can't resume: %s", err)
+	}
+
+	cp, err := r.readCheckpoint()
+	if err == nil {
+		if cp.Vcs != r.vcs {
+			return nil, fmt.Errorf("can't resume: %s is for %s, not %s", r.checkpointPath(), cp.Vcs, r.vcs)
+		}
+		if cp.Seed != r.Seed {
+			return nil, fmt.Errorf("can't resume: %s is for seed %d, not %d", r.checkpointPath(), cp.Seed, r.Seed)
+		}
+		numCommits, err := r.backend.NumCommits(r)
+		if err != nil && cp.Commits > 0 {
+			return nil, fmt.Errorf("can't resume: %s", err)
+		}
+		if err != nil || numCommits == cp.Commits {
+			return cp, nil
+		}
+		if len(cp.Moved) > 0 {
+			return nil, fmt.Errorf("can't resume: %s has %d commits, but the repo has %d",
+				r.checkpointPath(), cp.Commits, numCommits)
+		}
+		fmt.Printf("%s has %d commits, but the repo has %d; resuming from the repo\n",
+			r.checkpointPath(), cp.Commits, numCommits)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := r.loadInfo(); err != nil {
+		return nil, fmt.Errorf("can't resume: %s", err)
+	}
+	return &Checkpoint{Commits: r.numCommits, Files: r.numHeadFiles, IndexFiles: r.numHeadFiles}, nil
+}
@@ -0,0 +1,134 @@
+// vcs-torture/vcs/checkpoint_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dest, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	r := NewRepo(dest, "repo", "git", time.Now(), RepoOptions{Seed: 7})
+	if _, err := r.readCheckpoint(); !os.IsNotExist(err) {
+		t.Errorf("readCheckpoint with no checkpoint: %v", err)
+	}
+
+	r.indexFiles = 95
+	r.moved = map[string]string{"a/b.txt": "a/c.txt", "d.txt": ""}
+	if err := r.writeCheckpoint(r.checkpoint(10, 100)); err != nil {
+		t.Fatalf("writeCheckpoint: %s", err)
+	}
+	cp, err := r.readCheckpoint()
+	if err != nil {
+		t.Fatalf("readCheckpoint: %s", err)
+	}
+	if cp.Vcs != "git" || cp.Repo != "repo" || cp.Commits != 10 || cp.Files != 100 || cp.IndexFiles != 95 ||
+		cp.Seed != 7 || len(cp.Moved) != 2 || cp.Moved["a/b.txt"] != "a/c.txt" || cp.Moved["d.txt"] != "" {
+		t.Errorf("checkpoint is %+v", cp)
+	}
+}
+
+// TestResumePoint makes sure that a resumed run starts from a
+// checkpoint that matches the repo, from the repo if the checkpoint is
+// out of date, and not at all if that would lose track of moved files
+func TestResumePoint(t *testing.T) {
+	dest, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	b := &fakeBackend{commits: 12, files: 120}
+	r := NewRepo(dest, "repo", "fake", time.Now(), RepoOptions{})
+	r.backend = b
+	if _, err := r.resumePoint(); err == nil {
+		t.Errorf("resumed without a repo")
+	}
+	if err := r.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	// No checkpoint: go by the repo
+	cp, err := r.resumePoint()
+	if err != nil || cp.Commits != 12 || cp.Files != 120 {
+		t.Errorf("resumePoint with no checkpoint = %+v, %v", cp, err)
+	}
+
+	// A checkpoint that matches
+	r.indexFiles = 110
+	r.writeCheckpoint(r.checkpoint(12, 125))
+	cp, err = r.resumePoint()
+	if err != nil || cp.Commits != 12 || cp.Files != 125 || cp.IndexFiles != 110 {
+		t.Errorf("resumePoint with a checkpoint = %+v, %v", cp, err)
+	}
+
+	// Out of date: go by the repo, unless files have been moved
+	b.commits = 13
+	cp, err = r.resumePoint()
+	if err != nil || cp.Commits != 13 || cp.Files != 120 {
+		t.Errorf("resumePoint with an old checkpoint = %+v, %v", cp, err)
+	}
+	r.moved = map[string]string{"a.txt": ""}
+	r.writeCheckpoint(r.checkpoint(12, 125))
+	if cp, err = r.resumePoint(); err == nil || !strings.Contains(err.Error(), "has 12 commits, but the repo has 13") {
+		t.Errorf("resumePoint with an old checkpoint and moved files = %+v, %v", cp, err)
+	}
+
+	// Another run's checkpoint
+	r.moved = nil
+	r.Seed = 1
+	if cp, err = r.resumePoint(); err == nil || !strings.Contains(err.Error(), "seed") {
+		t.Errorf("resumePoint with another seed's checkpoint = %+v, %v", cp, err)
+	}
+}
+
+// TestCommitResume makes sure that skipped commits aren't checkpointed,
+// and that a resumed run carries on from the checkpoint
+func TestCommitResume(t *testing.T) {
+	dest, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	b := &fakeBackend{fail: map[int]bool{2: true, 4: true}}
+	options := RepoOptions{NumCommits: 4, AddsPerCommit: 2, FilesPerAdd: 5, OnError: ErrorSkip}
+	r := newFakeRepo(t, dest, b, 60, options)
+	if err := r.Commit(nil); err != nil {
+		t.Fatalf("Commit: %s", err)
+	}
+	cp, err := r.readCheckpoint()
+	if err != nil || cp.Commits != 2 || cp.Files != 40 || cp.IndexFiles != 40 {
+		t.Errorf("checkpoint after skipping two commits is %+v, %v", cp, err)
+	}
+	if b.commits != 2 || b.files != 30 {
+		t.Errorf("repo has %d commits of %d files, expected 2 of 30", b.commits, b.files)
+	}
+
+	// Resuming picks up after the last commit that worked, and runs out
+	// of files
+	options.Resume = true
+	options.NumCommits = 6
+	r = NewRepo(dest, "repo", "fake", time.Now(), options)
+	r.backend = b
+	r.AddWorktree(WorktreeOptions{NumFiles: 60, FilesPerDir: 10, DirsPerDir: 4, FileSize: 100})
+	r.Worktree.Generate(nil)
+	err = r.Commit(nil)
+	if !errors.Is(err, ErrWorktreeExhausted) {
+		t.Errorf("Commit = %v, expected the worktree to run out", err)
+	}
+	if cp, _ := r.readCheckpoint(); cp.Commits != 4 || cp.Files != 60 || b.commits != 4 {
+		t.Errorf("checkpoint after resuming is %+v with %d commits", cp, b.commits)
+	}
+}
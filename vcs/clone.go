@@ -0,0 +1,125 @@
+// vcs-torture/vcs/clone.go
+
+package vcs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CloneStats describes one clone made by a clone run
+type CloneStats struct {
+	Dir  string
+	Mode string
+
+	// How long copying history and filling in the worktree took
+	CloneTime    float64
+	CheckoutTime float64
+
+	// Size of the whole clone, and of its store (where it has one
+	// of its own; hard-linked files are counted in full)
+	DiskBytes  int64
+	DiskFiles  int64
+	StoreBytes int64
+	StoreFiles int64
+}
+
+// cloneDir is where a clone in the given mode goes
+func (r *Repo) cloneDir(mode string) string {
+	return r.repo + "-clone-" + mode
+}
+
+// Clone clones the repo to a sibling directory in r.CloneMode, replacing
+// any earlier clone in that mode, and records the timings and size of
+// the result. The clone is left behind (and removed with the repo).
+func (r *Repo) Clone() (*CloneStats, error) {
+	if r.backend == nil {
+		return nil, fmt.Errorf("Unknown version control system: %s", r.vcs)
+	}
+	if _, err := os.Stat(r.repo); err != nil {
+		return nil, err
+	}
+
+	modes := r.backend.CloneModes()
+	mode := r.CloneMode
+	if mode == "" {
+		mode = modes[0]
+	}
+	known := false
+	for _, m := range modes {
+		known = known || m == mode
+	}
+	if !known {
+		return nil, fmt.Errorf("%s can't make a %s clone (use one of: %s)", r.vcs, mode, strings.Join(modes, ", "))
+	}
+
+	dir := r.cloneDir(mode)
+	if err := r.freshClone(dir); err != nil {
+		return nil, err
+	}
+
+	if err := r.calibrate(r.Calibrate); err != nil {
+		return nil, err
+	}
+
+	numCommits, err := r.backend.NumCommits(r)
+	if err != nil {
+		return nil, err
+	}
+	r.commit = numCommits
+
+	if r.aborted() {
+		return nil, fmt.Errorf("%w before cloning", ErrAborted)
+	}
+	stats := &CloneStats{Dir: dir, Mode: mode}
+	stats.CloneTime, stats.CheckoutTime, err = r.backend.Clone(r, dir, mode)
+	if r.aborted() {
+		// ctrl-c reaches the clone command too, which is why it failed
+		return nil, fmt.Errorf("%w while cloning", ErrAborted)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Sizes aren't part of the timings
+	if stats.DiskBytes, stats.DiskFiles, err = dirUsage(dir); err != nil {
+		return nil, err
+	}
+	clone := NewRepo(r.dest, filepath.Base(dir), r.vcs, r.startTime, RepoOptions{})
+	if store := r.backend.StoreDir(clone); strings.HasPrefix(store, dir+string(filepath.Separator)) {
+		if stats.StoreBytes, stats.StoreFiles, err = dirUsage(store); err != nil {
+			return nil, err
+		}
+	}
+
+	rec := &Result{Op: "clone-summary", Mode: mode, Elapsed: stats.CloneTime + stats.CheckoutTime,
+		CloneTime: stats.CloneTime, CheckoutTime: stats.CheckoutTime,
+		DiskBytes: stats.DiskBytes, DiskFiles: stats.DiskFiles,
+		StoreBytes: stats.StoreBytes, StoreFiles: stats.StoreFiles}
+	if r.overhead > 0 {
+		rec.Overhead = r.overhead
+		rec.Corrected = r.corrected(stats.CloneTime) + r.corrected(stats.CheckoutTime)
+	}
+	r.record(rec)
+	return stats, nil
+}
+
+// freshClone gets dir ready for a new clone: a clone made earlier is
+// removed (but only if we made it), and the new one is noted as ours
+func (r *Repo) freshClone(dir string) error {
+	if _, err := os.Lstat(dir); err == nil {
+		m, _, err := LoadManifest(r.dest)
+		if err != nil {
+			return err
+		}
+		if !m.Owns(dir) {
+			return fmt.Errorf("refusing to replace %s: not created by vcs-torture", dir)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return recordOwned(r.dest, OwnedClone, r.vcs, dir)
+}
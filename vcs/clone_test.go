@@ -0,0 +1,99 @@
+// vcs-torture/vcs/clone_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func (*fakeBackend) CloneModes() []string {
+	return []string{"full", "shallow"}
+}
+
+func (b *fakeBackend) Clone(r *Repo, dir string, mode string) (float64, float64, error) {
+	b.log = append(b.log, "clone "+filepath.Base(dir)+" "+mode)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return 0, 0, err
+	}
+	return 0.5, 0.25, ioutil.WriteFile(filepath.Join(dir, "a.txt"), make([]byte, 1000), 0644)
+}
+
+func TestClone(t *testing.T) {
+	dest, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	path := filepath.Join(dest, "results.ndjson")
+	results, err := OpenResults(path, "run")
+	if err != nil {
+		t.Fatalf("OpenResults: %s", err)
+	}
+	b := &fakeBackend{commits: 7}
+	r := NewRepo(dest, "repo", "fake", time.Now(), RepoOptions{})
+	r.backend = b
+	r.SetResults(results)
+	if err := r.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first mode is the default; a clone replaces one made before
+	for i := 0; i < 2; i++ {
+		stats, err := r.Clone()
+		if err != nil {
+			t.Fatalf("Clone: %s", err)
+		}
+		if stats.Dir != r.repo+"-clone-full" || stats.Mode != "full" || stats.CloneTime != 0.5 || stats.CheckoutTime != 0.25 ||
+			stats.DiskBytes != 1000 || stats.DiskFiles != 2 {
+			t.Errorf("clone is %+v", stats)
+		}
+	}
+	r.CloneMode = "shallow"
+	if _, err := r.Clone(); err != nil {
+		t.Fatalf("Clone: %s", err)
+	}
+	r.CloneMode = "bare"
+	if _, err := r.Clone(); err == nil || !strings.Contains(err.Error(), "full, shallow") {
+		t.Errorf("Clone in an unknown mode: %v", err)
+	}
+	results.Close()
+	if strings.Join(b.log, ",") != "clone repo-clone-full full,clone repo-clone-full full,clone repo-clone-shallow shallow" {
+		t.Errorf("Clone did %q", b.log)
+	}
+
+	records, _ := readResults(t, path)
+	if len(records) != 3 {
+		t.Fatalf("%d records, expected 3", len(records))
+	}
+	rec := records[2]
+	if rec.Op != "clone-summary" || rec.Mode != "shallow" || rec.Commit != 7 || rec.Elapsed != 0.75 || rec.DiskBytes != 1000 {
+		t.Errorf("clone record is %+v", rec)
+	}
+
+	// A clone directory we didn't make isn't replaced, or removed with
+	// the repo
+	mine := r.repo + "-clone-mine"
+	os.Mkdir(mine, 0755)
+	if err := r.freshClone(mine); err == nil {
+		t.Errorf("freshClone replaced a directory we didn't make")
+	}
+	paths, err := DeleteRepo(dest, "repo", "", RemoveOptions{})
+	if err != nil || len(paths) != 3 {
+		t.Errorf("DeleteRepo = %v, %v", paths, err)
+	}
+	for _, dir := range []string{r.repo, r.repo + "-clone-full", r.repo + "-clone-shallow"} {
+		if _, err := os.Stat(dir); err == nil {
+			t.Errorf("%s wasn't removed", dir)
+		}
+	}
+	if _, err := os.Stat(mine); err != nil {
+		t.Errorf("%s was removed", mine)
+	}
+}
@@ -0,0 +1,233 @@
+// vcs-torture/vcs/content.go
+
+package vcs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Kinds of content a worktree file can have
+const (
+	// ContentText is pseudo-code made of a few dozen words (the
+	// default): it compresses well, and no two files are the same
+	ContentText = "text"
+
+	// ContentRandom is random bytes, which don't compress at all
+	ContentRandom = "random"
+
+	// ContentZero is long runs of the same byte (mostly zeros), which
+	// compress to almost nothing
+	ContentZero = "zero"
+
+	// ContentDuplicate is an exact copy of an earlier file
+	ContentDuplicate = "duplicate"
+
+	// ContentBinary is a binary format: every such file is the same
+	// random template with a header and a few bytes per block changed,
+	// so files are nearly identical to each other
+	ContentBinary = "binary"
+)
+
+// ContentKinds lists all the kinds of content
+var ContentKinds = []string{ContentText, ContentRandom, ContentZero, ContentDuplicate, ContentBinary}
+
+// ContentShare is a percentage of files with one kind of content
+type ContentShare struct {
+	Kind    string
+	Percent float64
+}
+
+// ContentMix is how a worktree's files are split between kinds of
+// content; the percentages add up to 100. An empty mix is all text.
+type ContentMix []ContentShare
+
+// ParseContentMix converts a comma-separated list of kinds with
+// percentages (e.g. text:70,random:20,duplicate:10); a single kind
+// can leave out its percentage
+func ParseContentMix(list string) (ContentMix, error) {
+	var mix ContentMix
+	total := 0.0
+	for _, item := range strings.Split(list, ",") {
+		share := ContentShare{Kind: item, Percent: 100}
+		if i := strings.IndexByte(item, ':'); i >= 0 {
+			percent, err := strconv.ParseFloat(item[i+1:], 64)
+			if err != nil || percent < 0 {
+				return nil, fmt.Errorf("bad percentage in '%s'", item)
+			}
+			share = ContentShare{Kind: item[:i], Percent: percent}
+		}
+		known := false
+		for _, kind := range ContentKinds {
+			known = known || kind == share.Kind
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown content '%s' (use %s)", share.Kind, strings.Join(ContentKinds, ", "))
+		}
+		mix = append(mix, share)
+		total += share.Percent
+	}
+	if total < 99.99 || total > 100.01 {
+		return nil, fmt.Errorf("content percentages add up to %g, not 100", total)
+	}
+	return mix, nil
+}
+
+// String is the mix the way ParseContentMix takes it
+func (m ContentMix) String() string {
+	var items []string
+	for _, share := range m {
+		items = append(items, fmt.Sprintf("%s:%g", share.Kind, share.Percent))
+	}
+	return strings.Join(items, ",")
+}
+
+// kindFor picks the kind of content for file nth; it depends only on
+// the mix, the seed and nth
+func (m ContentMix) kindFor(seed int64, nth int) string {
+	if len(m) == 0 {
+		return ContentText
+	}
+	point := float64(mixSeed(int64(mixSeed(seed))^int64(nth))%10000) / 100
+	for _, share := range m {
+		if point < share.Percent {
+			return share.Kind
+		}
+		point -= share.Percent
+	}
+	return m[len(m)-1].Kind
+}
+
+// contentSource is the kind of content file nth has, and which file it
+// comes from: nth itself, unless it's a duplicate of an earlier file
+// (which may itself be a duplicate). The first file has nothing to copy.
+func (w *Worktree) contentSource(nth int) (string, int) {
+	kind := w.Content.kindFor(w.Seed, nth)
+	for kind == ContentDuplicate && nth > 0 {
+		nth = int(mixSeed(w.Seed^int64(nth)) % uint64(nth))
+		kind = w.Content.kindFor(w.Seed, nth)
+	}
+	return kind, nth
+}
+
+// fileSize is the size of file nth (a duplicate is the size of the file
+// it copies)
+func (w *Worktree) fileSize(nth int) int64 {
+	sizes := w.Sizes
+	if sizes == nil && w.Profile != nil {
+		sizes = w.Profile.sizes
+	}
+	if sizes == nil {
+		return w.FileSize
+	}
+	_, source := w.contentSource(nth)
+	return sizes.sizeFor(w.Seed, source)
+}
+
+// writeFileContent writes the content of worktree file nth to out, a
+// chunk at a time, returning its size
+func (w *Worktree) writeFileContent(out io.Writer, nth int) (int64, error) {
+	size := w.fileSize(nth)
+	kind, source := w.contentSource(nth)
+	switch kind {
+	case ContentRandom:
+		return size, writeRandomContent(out, seededRand(w.Seed, int64(source)), size)
+	case ContentZero:
+		return size, writeZeroContent(out, seededRand(w.Seed, int64(source)), size)
+	case ContentBinary:
+		return size, writeBinaryContent(out, w.Seed, source, size)
+	default:
+		return size, writeSeededContent(out, w.Seed, source, size)
+	}
+}
+
+func writeRandomContent(out io.Writer, rng *rand.Rand, size int64) error {
+	buf := make([]byte, contentChunk)
+	for size > 0 {
+		n := int64(len(buf))
+		if n > size {
+			n = size
+		}
+		rng.Read(buf[:n])
+		if _, err := out.Write(buf[:n]); err != nil {
+			return err
+		}
+		size -= n
+	}
+	return nil
+}
+
+// zeroRun is the longest run of one byte in zero content
+const zeroRun = 256 * 1024
+
+// writeZeroContent writes runs of up to zeroRun bytes; most are zeros,
+// the rest are another byte
+func writeZeroContent(out io.Writer, rng *rand.Rand, size int64) error {
+	buf := make([]byte, contentChunk)
+	for size > 0 {
+		run := int64(rng.Intn(zeroRun) + 1)
+		if run > size {
+			run = size
+		}
+		var b byte
+		if rng.Intn(4) == 0 {
+			b = byte(rng.Intn(255) + 1)
+		}
+		for i := range buf {
+			buf[i] = b
+		}
+		for left := run; left > 0; {
+			n := int64(len(buf))
+			if n > left {
+				n = left
+			}
+			if _, err := out.Write(buf[:n]); err != nil {
+				return err
+			}
+			left -= n
+		}
+		size -= run
+	}
+	return nil
+}
+
+// binaryBlock is the size of the blocks that binary content changes a
+// few bytes in
+const binaryBlock = 4096
+
+// writeBinaryContent writes the seed's binary template, with a header
+// saying which file this is and 1 to 4 bytes of each block changed
+func writeBinaryContent(out io.Writer, seed int64, nth int, size int64) error {
+	template := seededRand(seed, -1)
+	changes := seededRand(seed, int64(nth))
+
+	var header [16]byte
+	copy(header[:], "VCST")
+	binary.LittleEndian.PutUint32(header[4:], 1)
+	binary.LittleEndian.PutUint64(header[8:], uint64(nth))
+
+	block := make([]byte, binaryBlock)
+	for pos := int64(0); pos < size; pos += binaryBlock {
+		template.Read(block)
+		if pos == 0 {
+			copy(block, header[:])
+		}
+		for n := changes.Intn(4) + 1; n > 0; n-- {
+			i := changes.Intn(binaryBlock-len(header)) + len(header)
+			block[i] ^= byte(changes.Intn(255) + 1)
+		}
+
+		n := size - pos
+		if n > binaryBlock {
+			n = binaryBlock
+		}
+		if _, err := out.Write(block[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// vcs-torture/vcs/content_test.go
+
+package vcs
+
+import (
+	"testing"
+)
+
+func TestParseContentMix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"text", "text:100", true},
+		{"random:100", "random:100", true},
+		{"text:70,random:20,duplicate:10", "text:70,random:20,duplicate:10", true},
+		{"binary:33.33,zero:33.33,text:33.34", "binary:33.33,zero:33.33,text:33.34", true},
+
+		{"", "", false},
+		{"video", "", false},
+		{"text,random", "", false},
+		{"text:70,random:20", "", false},
+		{"text:abc", "", false},
+		{"text:-10,random:110", "", false},
+	}
+	for _, test := range tests {
+		got, err := ParseContentMix(test.in)
+		if test.ok && (err != nil || got.String() != test.want) {
+			t.Errorf("ParseContentMix(%q) = %s, %v, expected %s", test.in, got, err, test.want)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("ParseContentMix(%q) = %s, expected an error", test.in, got)
+		}
+	}
+}
+
+// TestContentKinds makes sure that each kind of content gets about its
+// share of the files
+func TestContentKinds(t *testing.T) {
+	mix, err := ParseContentMix("text:70,random:20,duplicate:10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for nth := 0; nth < 10000; nth++ {
+		counts[mix.kindFor(42, nth)]++
+	}
+	for _, share := range mix {
+		want := int(share.Percent * 100)
+		if got := counts[share.Kind]; got < want*9/10 || got > want*11/10 {
+			t.Errorf("%d of 10000 files are %s, expected about %d", got, share.Kind, want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// vcs-torture/vcs/edit.go
+
+package vcs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Ways of changing a file that has already been committed
+const (
+	// EditAppend adds EditLines lines to the end
+	EditAppend = "append"
+
+	// EditInsert adds EditLines lines in the middle
+	EditInsert = "insert"
+
+	// EditRewrite overwrites RewriteFraction of the file in place
+	EditRewrite = "rewrite"
+
+	// EditReplace replaces the whole file with new content of the
+	// same size
+	EditReplace = "replace"
+)
+
+// EditStyles lists all the edit styles
+var EditStyles = []string{EditAppend, EditInsert, EditRewrite, EditReplace}
+
+// ParseEditStyles converts a comma-separated list of edit styles
+// ("all" for every style)
+func ParseEditStyles(list string) ([]string, error) {
+	if list == "all" {
+		return EditStyles, nil
+	}
+	var styles []string
+	for _, style := range strings.Split(list, ",") {
+		known := false
+		for _, s := range EditStyles {
+			known = known || s == style
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown edit style '%s' (use %s or all)", style, strings.Join(EditStyles, ", "))
+		}
+		styles = append(styles, style)
+	}
+	return styles, nil
+}
+
+// editLineSize is the length of an added line (content lines are
+// about 100 characters)
+const editLineSize = 100
+
+// pickModified chooses which of the first numCommitted worktree files
+// are changed in a commit. The choice depends only on the commit
+// number (and seed), so a run (or a resumed run) always changes the same
+// files.
+func (r *Repo) pickModified(commit int, numCommitted int) []int {
+	num := int(float64(numCommitted)*r.ModifyPercent/100 + 0.5)
+	if num > numCommitted {
+		num = numCommitted
+	}
+	if num <= 0 {
+		return nil
+	}
+
+	rng := seededRand(r.Seed, int64(commit))
+	if num*2 > numCommitted {
+		return rng.Perm(numCommitted)[:num]
+	}
+	picked := make(map[int]bool, num)
+	indexes := make([]int, 0, num)
+	for len(indexes) < num {
+		i := rng.Intn(numCommitted)
+		if !picked[i] {
+			picked[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// modifyFiles edits the chosen worktree files for a commit (wherever
+// they are now; deleted ones are passed over), returning their paths.
+// The styles are taken in turn.
+func (r *Repo) modifyFiles(commit int, indexes []int) ([]string, error) {
+	paths := make([]string, 0, len(indexes))
+	for n, i := range indexes {
+		path, ok := r.currentPath(i)
+		if !ok {
+			continue
+		}
+		style := r.EditStyles[(commit+n)%len(r.EditStyles)]
+
+		size, hash, err := editFile(filepath.Join(r.repo, path), style, r.Seed, commit*1000003+i, r.EditLines, r.RewriteFraction)
+		if err != nil {
+			return nil, err
+		}
+		r.indexBytes += r.Worktree.noteEdit(i, size, hash, commit)
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// editFile edits the file at fpath, returning its new size and hash.
+// Files can be any size, so the edited file is written a chunk at a time
+// next to the old one, then renamed over it.
+func editFile(fpath string, style string, seed int64, nth int, lines int, fraction float64) (int64, uint32, error) {
+	in, err := os.Open(fpath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tmp := fpath + ".tmp"
+	var out countingWriter
+	hash, err := writeContentFile(tmp, func(w io.Writer) error {
+		out.out = w
+		return writeEdit(&out, in, info.Size(), style, seed, nth, lines, fraction)
+	})
+	if err == nil {
+		in.Close()
+		err = os.Rename(tmp, fpath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, 0, err
+	}
+	return out.n, hash, nil
+}
+
+// countingWriter counts what goes through it
+type countingWriter struct {
+	out io.Writer
+	n   int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.out.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeEdit writes an edited version of old (which is oldSize bytes) to
+// out; seed and nth pick the new content
+func writeEdit(out io.Writer, old io.Reader, oldSize int64, style string, seed int64, nth int, lines int, fraction float64) error {
+	added := int64(lines * editLineSize)
+	switch style {
+	case EditAppend:
+		if _, err := io.Copy(out, old); err != nil {
+			return err
+		}
+		return writeSeededContent(out, seed, nth, added)
+
+	case EditInsert:
+		// At the start of a line, half-way through
+		in := bufio.NewReaderSize(old, contentChunk)
+		if _, err := io.CopyN(out, in, oldSize/2); err != nil {
+			return err
+		}
+		for {
+			line, err := in.ReadSlice('\n')
+			if _, werr := out.Write(line); werr != nil {
+				return werr
+			}
+			if err == nil || err == io.EOF {
+				break
+			}
+			if err != bufio.ErrBufferFull {
+				return err
+			}
+		}
+		if err := writeSeededContent(out, seed, nth, added); err != nil {
+			return err
+		}
+		_, err := io.Copy(out, in)
+		return err
+
+	case EditRewrite:
+		size := int64(float64(oldSize) * fraction)
+		if size < 2 || size > oldSize {
+			return writeEdit(out, old, oldSize, EditReplace, seed, nth, lines, fraction)
+		}
+		start := int64(nth) % (oldSize - size + 1)
+		if _, err := io.CopyN(out, old, start); err != nil {
+			return err
+		}
+		if err := writeSeededContent(out, seed, nth, size); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(ioutil.Discard, old, size); err != nil {
+			return err
+		}
+		_, err := io.Copy(out, old)
+		return err
+
+	default:
+		size := oldSize
+		if size < 2 {
+			size = 2
+		}
+		return writeSeededContent(out, seed, nth, size)
+	}
+}
@@ -0,0 +1,122 @@
+// vcs-torture/vcs/edit_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+func TestParseEditStyles(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		ok   bool
+	}{
+		{"all", EditStyles, true},
+		{"append", []string{EditAppend}, true},
+		{"rewrite,insert", []string{EditRewrite, EditInsert}, true},
+
+		{"", nil, false},
+		{"shrink", nil, false},
+		{"append,", nil, false},
+		{"append,all", nil, false},
+	}
+	for _, test := range tests {
+		got, err := ParseEditStyles(test.in)
+		if test.ok && (err != nil || !reflect.DeepEqual(got, test.want)) {
+			t.Errorf("ParseEditStyles(%q) = %v, %v, expected %v", test.in, got, err, test.want)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("ParseEditStyles(%q) = %v, expected an error", test.in, got)
+		}
+	}
+}
+
+// seeded is the nth piece of content for seed 42
+func seeded(t *testing.T, nth int, size int64) []byte {
+	var buf bytes.Buffer
+	if err := writeSeededContent(&buf, 42, nth, size); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// join joins pieces of content
+func join(pieces ...[]byte) []byte {
+	return bytes.Join(pieces, nil)
+}
+
+// TestWriteEdit makes sure that each edit style changes only the part
+// of a file it should
+func TestWriteEdit(t *testing.T) {
+	const nth, lines = 3, 4
+	old := seeded(t, 1, contentChunk+5000)
+	size := int64(len(old))
+	added := seeded(t, nth, lines*editLineSize)
+
+	half := size / 2
+	cut := half + int64(bytes.IndexByte(old[half:], '\n')) + 1
+	part := size / 10
+	start := nth % (size - part + 1)
+
+	tests := []struct {
+		style    string
+		old      []byte
+		fraction float64
+		want     []byte
+	}{
+		{EditAppend, old, 0, join(old, added)},
+		{EditInsert, old, 0, join(old[:cut], added, old[cut:])},
+		{EditRewrite, old, 0.1, join(old[:start], seeded(t, nth, part), old[start+part:])},
+		{EditReplace, old, 0, seeded(t, nth, size)},
+
+		{EditAppend, nil, 0, added},
+		{EditInsert, []byte("no newline"), 0, join([]byte("no newline"), added)},
+		{EditRewrite, old, 0.00001, seeded(t, nth, size)},
+		{EditReplace, []byte("x"), 0, seeded(t, nth, 2)},
+	}
+	for i, test := range tests {
+		var out bytes.Buffer
+		err := writeEdit(&out, bytes.NewReader(test.old), int64(len(test.old)), test.style, 42, nth, lines, test.fraction)
+		if err != nil {
+			t.Errorf("%d: %s edit: %s", i, test.style, err)
+		} else if !bytes.Equal(out.Bytes(), test.want) {
+			t.Errorf("%d: %s edit of %d bytes made %d bytes, not the %d expected", i, test.style, len(test.old), out.Len(), len(test.want))
+		}
+	}
+}
+
+// TestEditFile makes sure that editFile replaces the file, and describes
+// the new one
+func TestEditFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(fpath, seeded(t, 1, 5000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	size, hash, err := editFile(fpath, EditInsert, 42, 3, 4, 0)
+	if err != nil {
+		t.Fatalf("editFile: %s", err)
+	}
+	gotSize, gotHash, err := hashFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 5400 || gotSize != size || gotHash != hash {
+		t.Errorf("editFile made %d bytes with hash %08x, and said %d with hash %08x", gotSize, gotHash, size, hash)
+	}
+	if _, err := os.Stat(fpath + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("editFile left %s.tmp behind", fpath)
+	}
+}
@@ -2,8 +2,305 @@
 
 package vcs
 
-// Run a Git command, returning elapsed time and stdout and stderr
-func RunGitCommand(repodir string, env []string, cmd ...string) (float64, []byte, []byte) {
+import (
+	"fmt"
+	"net/http/cgi"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"vcs-torture/gsos"
+)
+
+// Run a Git command, returning elapsed time, stdout and stderr in a CmdResult
+func RunGitCommand(repodir string, env []string, cmd ...string) (*CmdResult, error) {
 
 	return RunExternal("git", repodir, env, cmd...)
 }
+
+// gitBackend drives Git
+type gitBackend struct{}
+
+func init() {
+	RegisterBackend(gitBackend{})
+}
+
+func (gitBackend) Name() string {
+	return "git"
+}
+
+func (gitBackend) ServerDir(r *Repo) string {
+	return ""
+}
+
+func (gitBackend) Init(r *Repo) error {
+	// "git init"
+	if _, err := r.run("init", "git", r.repo, "init"); err != nil {
+		return err
+	}
+
+	// "git config gc.auto 0" (turn off auto GC for this repo)
+	if _, err := r.run("init", "git", r.repo, "config", "gc.auto", "0"); err != nil {
+		return err
+	}
+
+	// "git config gc.autodetach false" for good measure
+	_, err := r.run("init", "git", r.repo, "config", "gc.autodetach", "false")
+	return err
+}
+
+func (gitBackend) Remove(r *Repo) error {
+	return removeRepoDirs(r)
+}
+
+func (gitBackend) Add(r *Repo, files []string) (*CmdResult, error) {
+	return r.run("add", "git", r.repo, append([]string{"add"}, files...)...)
+}
+
+func (gitBackend) AddModified(r *Repo, files []string) (*CmdResult, error) {
+	return r.run("modify", "git", r.repo, append([]string{"add"}, files...)...)
+}
+
+func (gitBackend) Delete(r *Repo, files []string) (*CmdResult, error) {
+	return r.run("delete", "git", r.repo, append([]string{"rm", "-q", "-f"}, files...)...)
+}
+
+func (gitBackend) Move(r *Repo, from string, to string) (*CmdResult, error) {
+	return r.run("move", "git", r.repo, "mv", from, to)
+}
+
+func (gitBackend) Status(r *Repo) (*CmdResult, error) {
+	return r.run("status", "git", r.repo, "status", "--porcelain")
+}
+
+func (gitBackend) Commit(r *Repo, message string) (*CmdResult, error) {
+	return r.run("commit", "git", r.repo, "commit", "-m", message)
+}
+
+func (gitBackend) HeadFiles(r *Repo) (int, error) {
+	res, err := RunGitCommand(r.repo, nil, "ls-tree", "-r", "HEAD")
+	if err != nil {
+		return 0, err
+	}
+	return len(gsos.DataToLines(res.Stdout)), nil
+}
+
+func (gitBackend) NumCommits(r *Repo) (int, error) {
+	res, err := RunGitCommand(r.repo, nil, "log", "--oneline")
+	if err != nil {
+		return 0, err
+	}
+	return len(gsos.DataToLines(res.Stdout)), nil
+}
+
+func (gitBackend) VersionCommand() []string {
+	return []string{"git", "--version"}
+}
+
+func (gitBackend) StoreDir(r *Repo) string {
+	return filepath.Join(r.repo, ".git")
+}
+
+func (gitBackend) StoreStats(r *Repo, stats *StoreStats) error {
+	// "git count-objects -v" counts loose objects ("count") and packed
+	// objects ("in-pack"); its sizes are in KiB
+	res, err := RunGitCommand(r.repo, nil, "count-objects", "-v")
+	if err != nil {
+		return err
+	}
+	parseNativeStats(res.Stdout, ":", stats.Native)
+	stats.LooseObjects = stats.Native["count"]
+	stats.PackObjects = stats.Native["in-pack"]
+	return nil
+}
+
+func (gitBackend) Revisions(r *Repo) ([]string, error) {
+	main, err := gitMainBranch(r)
+	if err != nil {
+		return nil, err
+	}
+	res, err := RunGitCommand(r.repo, nil, "rev-list", "--reverse", "--first-parent", main)
+	if err != nil {
+		return nil, err
+	}
+	return gsos.DataToLines(res.Stdout), nil
+}
+
+func (gitBackend) Branch(r *Repo, name string, rev string) (float64, error) {
+	res, err := r.run("branch", "git", r.repo, "branch", name, rev)
+	return res.Elapsed, err
+}
+
+func (gitBackend) Branches(r *Repo) ([]string, error) {
+	res, err := RunGitCommand(r.repo, nil, "for-each-ref", "--format=%(refname:short)", "refs/heads")
+	if err != nil {
+		return nil, err
+	}
+	return gsos.DataToLines(res.Stdout), nil
+}
+
+func (gitBackend) Checkout(r *Repo, branch string, rev string) (*CmdResult, error) {
+	if branch == "" && rev == "" {
+		main, err := gitMainBranch(r)
+		if err != nil {
+			return &CmdResult{Exe: "git"}, err
+		}
+		branch = main
+	}
+	if branch != "" {
+		return r.run("checkout", "git", r.repo, "checkout", "-q", branch)
+	}
+	return r.run("checkout", "git", r.repo, "checkout", "-q", "--detach", rev)
+}
+
+func (gitBackend) DiffFiles(r *Repo, from string, to string) (int, error) {
+	res, err := RunGitCommand(r.repo, nil, "diff", "--name-only", from, to)
+	if err != nil {
+		return 0, err
+	}
+	return len(gsos.DataToLines(res.Stdout)), nil
+}
+
+// gitMainBranch is the branch that commit runs commit to. That's
+// whatever HEAD is on, unless a checkout run was interrupted and left
+// HEAD detached, in which case it's main or master (whichever exists).
+func gitMainBranch(r *Repo) (string, error) {
+	if res, err := RunGitCommand(r.repo, nil, "symbolic-ref", "--short", "-q", "HEAD"); err == nil {
+		return strings.TrimSpace(string(res.Stdout)), nil
+	}
+	for _, name := range []string{"main", "master"} {
+		if _, err := RunGitCommand(r.repo, nil, "rev-parse", "--verify", "-q", "refs/heads/"+name); err == nil {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("git: can't find the main branch of %s", r.repo)
+}
+
+// Git clone modes: full copies history over the file:// transport,
+// local hard links the objects (what a plain clone of a path does),
+// no-local copies a path without hard links, shared borrows the
+// source's objects (.git/objects/info/alternates), and shallow only
+// fetches the last r.CloneDepth commits.
+func (gitBackend) CloneModes() []string {
+	return []string{"full", "local", "no-local", "shared", "shallow"}
+}
+
+func (gitBackend) Clone(r *Repo, dir string, mode string) (float64, float64, error) {
+	src := r.repoName
+	params := []string{"clone", "-q", "--no-checkout"}
+	switch mode {
+	case "full":
+		src = fileURL(r.repo)
+	case "local":
+		params = append(params, "--local")
+	case "no-local":
+		params = append(params, "--no-local")
+	case "shared":
+		params = append(params, "--shared")
+	case "shallow":
+		depth := r.CloneDepth
+		if depth <= 0 {
+			depth = 1
+		}
+		// --depth is ignored for plain paths
+		src = fileURL(r.repo)
+		params = append(params, "--depth", strconv.Itoa(depth))
+	}
+	params = append(params, src, filepath.Base(dir))
+
+	res, err := r.run("clone", "git", r.dest, params...)
+	if err != nil {
+		return res.Elapsed, 0, err
+	}
+
+	// With nothing in the index, a forced checkout of HEAD writes out
+	// the whole tree
+	checkout, err := r.run("clone-checkout", "git", dir, "checkout", "-q", "-f", "HEAD")
+	return res.Elapsed, checkout.Elapsed, err
+}
+
+// Git is served over smart HTTP by git http-backend, run as a CGI
+// program by an HTTP server inside this process, or over the git
+// protocol by git daemon
+func (gitBackend) ServeProtocols() []string {
+	return []string{"http", "git", "file"}
+}
+
+func (gitBackend) Serve(r *Repo, protocol string) (*Server, error) {
+	switch protocol {
+	case "file":
+		return &Server{Protocol: protocol, URL: fileURL(r.repo)}, nil
+	case "git":
+		return gitDaemon(r)
+	}
+
+	exePath, err := lookupPath("git")
+	if err != nil {
+		return nil, err
+	}
+	root, err := filepath.Abs(r.dest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pushing over HTTP is normally only allowed for authenticated
+	// users; this is a throwaway server on localhost
+	handler := &cgi.Handler{
+		Path: exePath,
+		Args: []string{"http-backend"},
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + root,
+			"GIT_HTTP_EXPORT_ALL=1",
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.receivepack",
+			"GIT_CONFIG_VALUE_0=true",
+		},
+	}
+	s, err := startHTTPServer(protocol, handler)
+	if err != nil {
+		return nil, err
+	}
+	s.URL = "http://" + s.Addr + "/" + r.repoName
+	return s, nil
+}
+
+// gitDaemon serves everything in dest over the git protocol,
+// allowing pushes
+func gitDaemon(r *Repo) (*Server, error) {
+	root, err := filepath.Abs(r.dest)
+	if err != nil {
+		return nil, err
+	}
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	s, err := startServer(&Server{}, "git", port, root, "git", "daemon", "--reuseaddr", "--export-all",
+		"--enable=receive-pack", "--listen=127.0.0.1", "--port="+strconv.Itoa(port), "--base-path="+root, root)
+	if err != nil {
+		return nil, err
+	}
+	s.URL = "git://" + s.Addr + "/" + r.repoName
+	return s, nil
+}
+
+func (gitBackend) RemoteClone(r *Repo, url string, dir string) (*CmdResult, error) {
+	return r.run("net-clone", "git", r.dest, "clone", "-q", url, filepath.Base(dir))
+}
+
+func (gitBackend) RemotePush(r *Repo, url string, dir string, branch string, path string) (*CmdResult, error) {
+	for _, params := range [][]string{
+		{"checkout", "-q", "-b", branch},
+		{"add", path},
+		{"commit", "-q", "-m", "push " + branch},
+	} {
+		if res, err := r.run("net-commit", "git", dir, params...); err != nil {
+			return res, err
+		}
+	}
+	return r.run("net-push", "git", dir, "push", "-q", url, branch)
+}
+
+func (gitBackend) RemoteFetch(r *Repo, url string, dir string, branch string) (*CmdResult, error) {
+	return r.run("net-fetch", "git", dir, "fetch", "-q", url, branch)
+}
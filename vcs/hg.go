@@ -2,8 +2,256 @@
 
 package vcs
 
-// Run a Mercurial command, returning elapsed time and stdout and stderr
-func RunHgCommand(repodir string, env []string, cmd ...string) (float64, []byte, []byte) {
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"vcs-torture/gsos"
+)
+
+// Run a Mercurial command, returning elapsed time, stdout and stderr in a CmdResult
+func RunHgCommand(repodir string, env []string, cmd ...string) (*CmdResult, error) {
 
 	return RunExternal("hg", repodir, env, cmd...)
 }
+
+// hgBackend drives Mercurial
+type hgBackend struct{}
+
+func init() {
+	RegisterBackend(hgBackend{})
+}
+
+func (hgBackend) Name() string {
+	return "hg"
+}
+
+func (hgBackend) ServerDir(r *Repo) string {
+	return ""
+}
+
+func (hgBackend) Init(r *Repo) error {
+	// "hg init"
+	_, err := r.run("init", "hg", r.repo, "init")
+	return err
+}
+
+func (hgBackend) Remove(r *Repo) error {
+	return removeRepoDirs(r)
+}
+
+func (hgBackend) Add(r *Repo, files []string) (*CmdResult, error) {
+	return r.run("add", "hg", r.repo, append([]string{"add"}, files...)...)
+}
+
+func (hgBackend) AddModified(r *Repo, files []string) (*CmdResult, error) {
+	// hg commit picks up changes to tracked files
+	return &CmdResult{Exe: "hg"}, nil
+}
+
+func (hgBackend) Delete(r *Repo, files []string) (*CmdResult, error) {
+	return r.run("delete", "hg", r.repo, append([]string{"remove", "-f"}, files...)...)
+}
+
+func (hgBackend) Move(r *Repo, from string, to string) (*CmdResult, error) {
+	return r.run("move", "hg", r.repo, "mv", from, to)
+}
+
+func (hgBackend) Status(r *Repo) (*CmdResult, error) {
+	return r.run("status", "hg", r.repo, "status")
+}
+
+func (hgBackend) Commit(r *Repo, message string) (*CmdResult, error) {
+	return r.run("commit", "hg", r.repo, "commit", "-m", message)
+}
+
+func (hgBackend) HeadFiles(r *Repo) (int, error) {
+	// "hg manifest" lists every file in a revision. Commit runs commit
+	// to the default branch; tip may be on a named branch, or be a
+	// pushed commit. An empty repo has no default yet, only null.
+	res, err := RunHgCommand(r.repo, nil, "manifest", "-r", "max(present(default) + null)")
+	if err != nil {
+		return 0, err
+	}
+	return len(gsos.DataToLines(res.Stdout)), nil
+}
+
+func (hgBackend) NumCommits(r *Repo) (int, error) {
+	// Count the default branch's history (a character per commit),
+	// rather than going by the tip revision number, which counts
+	// commits on every branch
+	res, err := RunHgCommand(r.repo, nil, "log", "-r", "ancestors(present(default))", "--template", "x")
+	if err != nil {
+		return 0, err
+	}
+	return len(bytes.TrimSpace(res.Stdout)), nil
+}
+
+func (hgBackend) VersionCommand() []string {
+	return []string{"hg", "version"}
+}
+
+func (hgBackend) StoreDir(r *Repo) string {
+	return filepath.Join(r.repo, ".hg")
+}
+
+func (hgBackend) StoreStats(r *Repo, stats *StoreStats) error {
+	// History is kept in revlogs under .hg/store: an index (.i) per
+	// file, plus a data file (.d) once the revlog is too big to keep
+	// inline. Mercurial has no separate loose and packed storage.
+	store := filepath.Join(r.repo, ".hg", "store")
+	return filepath.Walk(store, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch filepath.Ext(path) {
+		case ".i":
+			stats.Native["revlogs"]++
+			stats.Native["revlog-index-bytes"] += info.Size()
+		case ".d":
+			stats.Native["revlog-data-files"]++
+			stats.Native["revlog-data-bytes"] += info.Size()
+		}
+		return nil
+	})
+}
+
+func (hgBackend) Revisions(r *Repo) ([]string, error) {
+	// Commit runs always commit to the default branch; named branches
+	// made by Branch have commits of their own that aren't wanted here
+	res, err := RunHgCommand(r.repo, nil, "log", "-r", "branch(default)", "--template", "{node|short}\n")
+	if err != nil {
+		return nil, err
+	}
+	return gsos.DataToLines(res.Stdout), nil
+}
+
+func (hgBackend) Branch(r *Repo, name string, rev string) (float64, error) {
+	// A bookmark is just a name for a revision
+	if !r.NamedBranches {
+		res, err := r.run("branch", "hg", r.repo, "bookmark", "-r", rev, name)
+		return res.Elapsed, err
+	}
+
+	// A named branch only exists once something is committed on it,
+	// which means updating to where it starts first
+	var elapsed float64
+	for _, params := range [][]string{
+		{"update", "-q", "-r", rev},
+		{"branch", name},
+		{"commit", "-m", "branch " + name},
+	} {
+		res, err := r.run("branch", "hg", r.repo, params...)
+		elapsed += res.Elapsed
+		if err != nil {
+			return elapsed, err
+		}
+	}
+	return elapsed, nil
+}
+
+func (hgBackend) Branches(r *Repo) ([]string, error) {
+	// Both bookmarks and named branches (closed ones too)
+	var names []string
+	for _, params := range [][]string{
+		{"bookmarks", "--template", "{bookmark}\n"},
+		{"branches", "-c", "--template", "{branch}\n"},
+	} {
+		res, err := RunHgCommand(r.repo, nil, params...)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, gsos.DataToLines(res.Stdout)...)
+	}
+	return names, nil
+}
+
+func (hgBackend) Checkout(r *Repo, branch string, rev string) (*CmdResult, error) {
+	// Updating to a bookmark makes it active (so that later commits
+	// move it); updating to anything else deactivates it
+	target := branch
+	if target == "" {
+		target = rev
+	}
+	if target == "" {
+		target = "default"
+	}
+	return r.run("checkout", "hg", r.repo, "update", "-q", "-r", target)
+}
+
+func (hgBackend) DiffFiles(r *Repo, from string, to string) (int, error) {
+	res, err := RunHgCommand(r.repo, nil, "status", "--rev", from, "--rev", to)
+	if err != nil {
+		return 0, err
+	}
+	return len(gsos.DataToLines(res.Stdout)), nil
+}
+
+// Mercurial clone modes: full is a plain clone (which hard links the
+// store when it can), uncompressed streams the store files as they
+// are, and noupdate leaves the clone without a worktree.
+func (hgBackend) CloneModes() []string {
+	return []string{"full", "uncompressed", "noupdate"}
+}
+
+func (hgBackend) Clone(r *Repo, dir string, mode string) (float64, float64, error) {
+	params := []string{"clone", "-q", "--noupdate"}
+	if mode == "uncompressed" {
+		params = append(params, "--uncompressed")
+	}
+	params = append(params, r.repoName, filepath.Base(dir))
+
+	res, err := r.run("clone", "hg", r.dest, params...)
+	if err != nil || mode == "noupdate" {
+		return res.Elapsed, 0, err
+	}
+
+	checkout, err := r.run("clone-checkout", "hg", dir, "update", "-q")
+	return res.Elapsed, checkout.Elapsed, err
+}
+
+// Mercurial is served by hg serve, its built-in web server
+func (hgBackend) ServeProtocols() []string {
+	return []string{"http", "file"}
+}
+
+func (hgBackend) Serve(r *Repo, protocol string) (*Server, error) {
+	if protocol == "file" {
+		return &Server{Protocol: protocol, URL: fileURL(r.repo)}, nil
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	s, err := startServer(&Server{}, protocol, port, r.repo, "hg", "serve", "-a", "127.0.0.1", "-p", strconv.Itoa(port),
+		"--config", "web.push_ssl=False", "--config", "web.allow-push=*")
+	if err != nil {
+		return nil, err
+	}
+	s.URL = "http://" + s.Addr + "/"
+	return s, nil
+}
+
+func (hgBackend) RemoteClone(r *Repo, url string, dir string) (*CmdResult, error) {
+	return r.run("net-clone", "hg", r.dest, "clone", "-q", url, filepath.Base(dir))
+}
+
+func (hgBackend) RemotePush(r *Repo, url string, dir string, branch string, path string) (*CmdResult, error) {
+	for _, params := range [][]string{
+		{"branch", branch},
+		{"add", "-q", path},
+		{"commit", "-m", "push " + branch},
+	} {
+		if res, err := r.run("net-commit", "hg", dir, params...); err != nil {
+			return res, err
+		}
+	}
+	return r.run("net-push", "hg", dir, "push", "-q", "--new-branch", "-b", branch, url)
+}
+
+func (hgBackend) RemoteFetch(r *Repo, url string, dir string, branch string) (*CmdResult, error) {
+	return r.run("net-fetch", "hg", dir, "pull", "-q", "-b", branch, url)
+}
@@ -0,0 +1,57 @@
+// vcs-torture/vcs/hg_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// TestHgCounts makes sure that head files and commits are counted on
+// the default branch (not tip), with a stand-in for hg
+func TestHgCounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer fakeCommand(t, dir, "hg", `case "$*" in
+"manifest -r max(present(default) + null)") printf 'a.txt\nd/b.txt\nd/e/c.txt\n' ;;
+"log -r ancestors(present(default)) --template x") printf xxxxx ;;
+*) echo "hg: unexpected $*" >&2; exit 255 ;;
+esac
+`)()
+
+	r := NewRepo(dir, "repo", "hg", time.Now(), RepoOptions{})
+	os.Mkdir(r.repo, 0755)
+	if n, err := r.backend.HeadFiles(r); n != 3 || err != nil {
+		t.Errorf("HeadFiles = %d, %v, expected 3", n, err)
+	}
+	if n, err := r.backend.NumCommits(r); n != 5 || err != nil {
+		t.Errorf("NumCommits = %d, %v, expected 5", n, err)
+	}
+}
+
+// TestHgEmpty makes sure that an empty repo has nothing in it
+func TestHgEmpty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer fakeCommand(t, dir, "hg", "exit 0\n")()
+
+	r := NewRepo(dir, "repo", "hg", time.Now(), RepoOptions{})
+	os.Mkdir(r.repo, 0755)
+	if n, err := r.backend.HeadFiles(r); n != 0 || err != nil {
+		t.Errorf("HeadFiles = %d, %v, expected 0", n, err)
+	}
+	if n, err := r.backend.NumCommits(r); n != 0 || err != nil {
+		t.Errorf("NumCommits = %d, %v, expected 0", n, err)
+	}
+}
@@ -0,0 +1,155 @@
+// vcs-torture/vcs/layout.go
+
+package vcs
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Ways of laying out worktree files in directories
+const (
+	// LayoutBalanced fills each directory with FilesPerDir files, then
+	// DirsPerDir subdirectories, level by level (the default)
+	LayoutBalanced = "balanced"
+
+	// LayoutFlat puts every file in the top directory
+	LayoutFlat = "flat"
+
+	// LayoutDeep nests directories as deeply as it can: each holds
+	// FilesPerDir files and one subdirectory, for up to deepLevels
+	// levels, then another chain starts at the top
+	LayoutDeep = "deep"
+
+	// LayoutZipf has NumFiles/FilesPerDir directories, with files spread
+	// over them by Zipf's law: a few directories are huge, and most are
+	// small
+	LayoutZipf = "zipf"
+
+	// LayoutMonorepo has many top-level projects of FilesPerDir*DirsPerDir
+	// files each, laid out the same way (src, test, docs and so on)
+	LayoutMonorepo = "monorepo"
+)
+
+// Layouts lists all the layouts
+var Layouts = []string{LayoutBalanced, LayoutFlat, LayoutDeep, LayoutZipf, LayoutMonorepo}
+
+// ParseLayout checks a layout name ("" is the default)
+func ParseLayout(name string) (string, error) {
+	if name == "" {
+		return LayoutBalanced, nil
+	}
+	for _, layout := range Layouts {
+		if layout == name {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unknown layout '%s' (use %s)", name, strings.Join(Layouts, ", "))
+}
+
+// deepLevels is how deep a chain of directories gets in LayoutDeep;
+// paths are then about 500 characters, which is more than Windows'
+// MAX_PATH (260), so there long paths must be turned on
+const deepLevels = 250
+
+// zipfSkew is the s of Zipf's law in LayoutZipf (above 1, and the
+// bigger it is, the more files the biggest directories get)
+const zipfSkew = 1.2
+
+// layoutStream keeps the choice of directories apart from other choices
+// made with the same seed
+const layoutStream = 0x1a70
+
+// monorepoAreas are the directories in each project of LayoutMonorepo
+var monorepoAreas = []string{"src", "src/internal", "test", "docs", "tools", "build"}
+
+// dirFor is the directory that file nth goes in ("" for the top). All
+// layouts but LayoutBalanced work it out from nth alone; that one (and
+// a profile) must be asked for files in order.
+func (w *Worktree) dirFor(nth int) string {
+	if w.Profile != nil {
+		return w.profileDir()
+	}
+	switch w.Layout {
+	case LayoutFlat:
+		return ""
+
+	case LayoutDeep:
+		level := nth / w.FilesPerDir
+		chain, depth := level/deepLevels, level%deepLevels
+		parts := []string{uniqueName(chain)}
+		for i := 0; i < depth; i++ {
+			parts = append(parts, string(rune('a'+i%26)))
+		}
+		return strings.Join(parts, "/")
+
+	case LayoutZipf:
+		numDirs := w.NumFiles / w.FilesPerDir
+		if numDirs < 2 {
+			return w.dirName(0)
+		}
+		return w.dirName(w.zipfDir(nth, numDirs))
+
+	case LayoutMonorepo:
+		perProject := w.FilesPerDir * w.DirsPerDir
+		project, n := nth/perProject, (nth%perProject)/w.FilesPerDir
+		area := monorepoAreas[n%len(monorepoAreas)]
+		if sub := n / len(monorepoAreas); sub > 0 {
+			area += "/" + w.dirName(sub-1)
+		}
+		return uniqueName(project) + "/" + area
+
+	default:
+		return w.getDir()
+	}
+}
+
+// zipfDir is which of numDirs directories file nth of LayoutZipf goes
+// in: directory k gets a share of the files in proportion to
+// 1/(k+1)^zipfSkew. The shares are added up once per Generate, and each
+// file hashes its position to a point in them, so a file's directory
+// still depends only on nth and the seed.
+func (w *Worktree) zipfDir(nth int, numDirs int) int {
+	if len(w.zipfCDF) != numDirs {
+		w.zipfCDF = make([]float64, numDirs)
+		total := 0.0
+		for k := range w.zipfCDF {
+			total += math.Pow(float64(k+1), -zipfSkew)
+			w.zipfCDF[k] = total
+		}
+	}
+
+	key := mixSeed(w.Seed ^ layoutStream)
+	point := float64(mixSeed(int64(key+uint64(nth)))>>11) / (1 << 53) * w.zipfCDF[numDirs-1]
+	return sort.Search(numDirs-1, func(k int) bool { return point < w.zipfCDF[k] })
+}
+
+// fileName is the name of file nth
+func (w *Worktree) fileName(nth int) string {
+	if w.Profile != nil {
+		return w.profileName(nth)
+	}
+	return uniqueName(nth)
+}
+
+// dirName is a directory path for directory n of a tree that has
+// DirsPerDir (2 or more) subdirectories in each directory. They are
+// named a to z, or with numbers if there are more than 26.
+func (w *Worktree) dirName(n int) string {
+	width := len(strconv.Itoa(w.DirsPerDir - 1))
+	var parts []string
+	for {
+		part := fmt.Sprintf("%0*d", width, n%w.DirsPerDir)
+		if w.DirsPerDir <= 26 {
+			part = string(rune('a' + n%w.DirsPerDir))
+		}
+		parts = append([]string{part}, parts...)
+		n /= w.DirsPerDir
+		if n == 0 {
+			return strings.Join(parts, "/")
+		}
+	}
+}
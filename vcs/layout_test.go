@@ -0,0 +1,74 @@
+// vcs-torture/vcs/layout_test.go
+
+package vcs
+
+import (
+	"testing"
+)
+
+func TestParseLayout(t *testing.T) {
+	if got, err := ParseLayout(""); got != LayoutBalanced || err != nil {
+		t.Errorf("ParseLayout(\"\") = %q, %v, expected %q", got, err, LayoutBalanced)
+	}
+	for _, layout := range Layouts {
+		if got, err := ParseLayout(layout); got != layout || err != nil {
+			t.Errorf("ParseLayout(%q) = %q, %v", layout, got, err)
+		}
+	}
+	for _, name := range []string{"spiral", "Flat", " flat"} {
+		if got, err := ParseLayout(name); err == nil {
+			t.Errorf("ParseLayout(%q) = %q, expected an error", name, got)
+		}
+	}
+}
+
+// TestZipfLayout makes sure that LayoutZipf puts files in the same
+// directories every time, and most of them in the first few
+func TestZipfLayout(t *testing.T) {
+	options := WorktreeOptions{NumFiles: 4800, FilesPerDir: 48, DirsPerDir: 16, Layout: LayoutZipf, Seed: 7}
+	w := NewWorktree("", "repo", options)
+	again := NewWorktree("", "repo", options)
+
+	counts := map[string]int{}
+	for nth := 0; nth < w.NumFiles; nth++ {
+		dir := w.dirFor(nth)
+		if other := again.dirFor(nth); other != dir {
+			t.Fatalf("file %d went in %s, then in %s", nth, dir, other)
+		}
+		counts[dir]++
+	}
+
+	// Directory k gets 1/(k+1)^1.2 of the files, over the sum of those
+	// for all 100 directories (about 3.6)
+	first, second, last := counts[w.dirName(0)], counts[w.dirName(1)], counts[w.dirName(99)]
+	if first < 1200 || first > 1500 || second < 500 || second > 650 || last > 20 {
+		t.Errorf("zipf directories got %d, %d, ... %d files, expected about 1330, 580, ... 5", first, second, last)
+	}
+	if len(counts) > 100 {
+		t.Errorf("zipf used %d directories, expected 100 at most", len(counts))
+	}
+}
+
+func TestDirName(t *testing.T) {
+	tests := []struct {
+		dirsPerDir int
+		n          int
+		want       string
+	}{
+		{16, 0, "a"},
+		{16, 15, "p"},
+		{16, 16, "b/a"},
+		{2, 5, "b/a/b"},
+		{26, 25, "z"},
+		{27, 26, "26"},
+		{27, 27, "01/00"},
+		{100, 7, "07"},
+		{100, 1234, "12/34"},
+	}
+	for _, test := range tests {
+		w := NewWorktree("", "repo", WorktreeOptions{DirsPerDir: test.dirsPerDir})
+		if got := w.dirName(test.n); got != test.want {
+			t.Errorf("dirName(%d) with %d per directory = %q, expected %q", test.n, test.dirsPerDir, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// vcs-torture/vcs/manifest.go
+
+package vcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestName is the file in --dest that lists everything we created
+// there. We refuse to delete anything that isn't listed in it, so that
+// a typo in --dest or --repo can't wipe out someone's hard disk.
+const ManifestName = ".vcs-torture"
+
+// Kinds of things we create
+const (
+	OwnedRepo       = "repo"
+	OwnedServer     = "server"
+	OwnedWorktree   = "worktree"
+	OwnedCheckpoint = "checkpoint"
+	OwnedClone      = "clone"
+
+	OwnedWorktreeManifest = "worktree-manifest"
+)
+
+// ManifestEntry is one thing we created. Path is relative to dest.
+type ManifestEntry struct {
+	Path    string    `json:"path"`
+	Kind    string    `json:"kind"`
+	Vcs     string    `json:"vcs,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Manifest is the contents of the ManifestName file
+type Manifest struct {
+	Entries []ManifestEntry `json:"entries"`
+
+	dest string
+}
+
+// LoadManifest reads the manifest in dest; if there isn't one yet,
+// it returns an empty manifest (and exists=false)
+func LoadManifest(dest string) (m *Manifest, exists bool, err error) {
+	m = &Manifest{dest: dest}
+	data, err := ioutil.ReadFile(filepath.Join(dest, ManifestName))
+	if os.IsNotExist(err) {
+		return m, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, true, fmt.Errorf("%s: %s", filepath.Join(dest, ManifestName), err)
+	}
+	return m, true, nil
+}
+
+// Save writes the manifest back to dest
+func (m *Manifest) Save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(m.dest, ManifestName)
+	if err := ioutil.WriteFile(path+".tmp", append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// rel turns a path into the dest-relative form kept in the manifest
+func (m *Manifest) rel(path string) string {
+	if rel, err := filepath.Rel(m.dest, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(filepath.Clean(path))
+}
+
+// Owns returns true if path (inside dest) is something we created
+func (m *Manifest) Owns(path string) bool {
+	rel := m.rel(path)
+	for _, e := range m.Entries {
+		if e.Path == rel {
+			return true
+		}
+	}
+	return false
+}
+
+// Add records that we created path (inside dest)
+func (m *Manifest) Add(kind string, vcs string, path string) {
+	if m.Owns(path) {
+		return
+	}
+	m.Entries = append(m.Entries, ManifestEntry{Path: m.rel(path), Kind: kind, Vcs: vcs, Created: time.Now()})
+}
+
+// Drop forgets about path (because it has been deleted)
+func (m *Manifest) Drop(path string) {
+	rel := m.rel(path)
+	entries := m.Entries[:0]
+	for _, e := range m.Entries {
+		if e.Path != rel {
+			entries = append(entries, e)
+		}
+	}
+	m.Entries = entries
+}
+
+// recordOwned adds path to the manifest in dest
+func recordOwned(dest string, kind string, vcs string, path string) error {
+	m, _, err := LoadManifest(dest)
+	if err != nil {
+		return err
+	}
+	if m.Owns(path) {
+		return nil
+	}
+	m.Add(kind, vcs, path)
+	return m.Save()
+}
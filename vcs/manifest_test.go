@@ -0,0 +1,127 @@
+// vcs-torture/vcs/manifest_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func TestManifest(t *testing.T) {
+	dest, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	m, exists, err := LoadManifest(dest)
+	if err != nil || exists || len(m.Entries) != 0 {
+		t.Fatalf("LoadManifest with no manifest = %+v, %t, %v", m, exists, err)
+	}
+
+	repo := filepath.Join(dest, "repo")
+	for i := 0; i < 2; i++ {
+		if err := recordOwned(dest, OwnedRepo, "git", repo); err != nil {
+			t.Fatalf("recordOwned: %s", err)
+		}
+	}
+	if err := recordOwned(dest, OwnedCheckpoint, "git", repo+"-checkpoint.json"); err != nil {
+		t.Fatalf("recordOwned: %s", err)
+	}
+
+	m, exists, err = LoadManifest(dest)
+	if err != nil || !exists {
+		t.Fatalf("LoadManifest = %t, %v", exists, err)
+	}
+	if len(m.Entries) != 2 || m.Entries[0].Path != "repo" || m.Entries[0].Kind != OwnedRepo || m.Entries[0].Vcs != "git" {
+		t.Errorf("manifest is %+v", m.Entries)
+	}
+	for path, want := range map[string]bool{
+		repo:                                 true,
+		repo + "-checkpoint.json":            true,
+		filepath.Join(dest, ".", "repo"):     true,
+		filepath.Join(dest, "repo", "a.txt"): false,
+		filepath.Join(dest, "other"):         false,
+		dest:                                 false,
+		filepath.Join(filepath.Dir(dest), "repo"): false,
+	} {
+		if m.Owns(path) != want {
+			t.Errorf("Owns(%s) = %t", path, !want)
+		}
+	}
+
+	m.Drop(repo)
+	if m.Owns(repo) || !m.Owns(repo+"-checkpoint.json") {
+		t.Errorf("after Drop, manifest is %+v", m.Entries)
+	}
+
+	// A manifest that can't be read is an error, not an empty manifest
+	ioutil.WriteFile(filepath.Join(dest, ManifestName), []byte("{"), 0644)
+	if _, _, err := LoadManifest(dest); err == nil {
+		t.Errorf("LoadManifest of a bad manifest worked")
+	}
+}
+
+// TestDeleteRepo makes sure that only things in the manifest are
+// removed, unless forced
+func TestDeleteRepo(t *testing.T) {
+	dest, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	repo := filepath.Join(dest, "repo")
+	mkdir := func(path string) {
+		if err := os.MkdirAll(filepath.Join(path, "sub"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	// Nothing is ours without a manifest
+	mkdir(repo)
+	if _, err := DeleteRepo(dest, "repo", "git", RemoveOptions{}); err == nil || !strings.Contains(err.Error(), "no "+ManifestName) {
+		t.Errorf("DeleteRepo without a manifest: %v", err)
+	}
+	if !exists(repo) {
+		t.Fatalf("DeleteRepo removed a repo it refused to")
+	}
+
+	// Nor is anything the manifest doesn't list
+	recordOwned(dest, OwnedRepo, "git", filepath.Join(dest, "other"))
+	if _, err := DeleteRepo(dest, "repo", "git", RemoveOptions{}); err == nil || !strings.Contains(err.Error(), "not created by") {
+		t.Errorf("DeleteRepo of a repo not in the manifest: %v", err)
+	}
+	if !exists(repo) {
+		t.Fatalf("DeleteRepo removed a repo it refused to")
+	}
+
+	// A forced dry run removes nothing
+	paths, err := DeleteRepo(dest, "repo", "git", RemoveOptions{Force: true, DryRun: true})
+	if err != nil || len(paths) != 1 || paths[0] != repo || !exists(repo) {
+		t.Errorf("DeleteRepo dry run = %v, %v", paths, err)
+	}
+
+	// Once it's in the manifest, it goes, and so does its entry
+	recordOwned(dest, OwnedRepo, "git", repo)
+	paths, err = DeleteRepo(dest, "repo", "git", RemoveOptions{})
+	if err != nil || len(paths) != 1 || exists(repo) {
+		t.Errorf("DeleteRepo = %v, %v", paths, err)
+	}
+	m, _, _ := LoadManifest(dest)
+	if m.Owns(repo) || !m.Owns(filepath.Join(dest, "other")) {
+		t.Errorf("after DeleteRepo, manifest is %+v", m.Entries)
+	}
+
+	if _, err := DeleteRepo(dest, "repo", "bzr", RemoveOptions{Force: true}); err == nil {
+		t.Errorf("DeleteRepo of an unknown version control system worked")
+	}
+}
@@ -0,0 +1,281 @@
+// vcs-torture/vcs/moves.go
+
+package vcs
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+)
+
+// How many deletes, renames and directory moves a commit gets. Rates
+// can be fractional (0.25 is one every fourth commit).
+func ratePerCommit(rate float64, commit int) int {
+	return int(rate*float64(commit)) - int(rate*float64(commit-1))
+}
+
+// currentPath is where worktree file i is now, after any renames and
+// directory moves; ok is false if it has been deleted
+func (r *Repo) currentPath(i int) (p string, ok bool) {
+	orig := r.Worktree.Files[i]
+	if moved, isMoved := r.moved[orig]; isMoved {
+		return moved, moved != ""
+	}
+	return orig, true
+}
+
+// pickLive chooses a file that is committed and hasn't been deleted,
+// or returns -1 if it can't find one
+func (r *Repo) pickLive(rng *rand.Rand, numCommitted int) int {
+	for try := 0; try < 100 && numCommitted > 0; try++ {
+		i := rng.Intn(numCommitted)
+		if _, ok := r.currentPath(i); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// reshape deletes, renames and moves committed files for a commit, as
+// r.DeleteRate, r.RenameRate and r.DirMoveRate ask, using the backend's
+// own commands so that it knows what happened. Like modifications, the
+// choices depend only on the commit number and what has happened
+// before. It returns raw and corrected times for the commands.
+func (r *Repo) reshape(commit int, numCommitted int) (float64, float64, error) {
+	if r.moved == nil {
+		r.moved = make(map[string]string)
+	}
+	rng := seededRand(r.Seed, int64(commit)<<8|1)
+
+	var elapsed, corrected float64
+	var firstErr error
+	timed := func(res *CmdResult, err error) bool {
+		elapsed += res.Elapsed
+		corrected += r.corrected(res.Elapsed)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return err == nil || r.OnError == ErrorSkip
+	}
+
+	// Deletes, all in one go (well, as few commands as will fit)
+	var deletes []int
+	var paths []string
+	for n := ratePerCommit(r.DeleteRate, commit); n > 0; n-- {
+		i := r.pickLive(rng, numCommitted)
+		if i < 0 {
+			break
+		}
+		p, _ := r.currentPath(i)
+		deletes = append(deletes, i)
+		paths = append(paths, p)
+		r.moved[r.Worktree.Files[i]] = ""
+	}
+	if len(paths) > 0 {
+		r.deleted = len(paths)
+		e, c, err := r.inBatches(paths, func(filelist []string) (*CmdResult, error) {
+			r.opFiles = len(filelist)
+			return r.backend.Delete(r, filelist)
+		})
+		elapsed += e
+		corrected += c
+		if err == nil {
+			for _, i := range deletes {
+				r.indexBytes -= r.Worktree.bytesOf(i, i+1)
+			}
+		} else {
+			// What didn't get deleted is hard to say; forget them all
+			for _, i := range deletes {
+				delete(r.moved, r.Worktree.Files[i])
+			}
+			firstErr = err
+			if r.OnError != ErrorSkip {
+				return elapsed, corrected, firstErr
+			}
+		}
+	}
+
+	// Renames within the same directory
+	for n := ratePerCommit(r.RenameRate, commit); n > 0; n-- {
+		i := r.pickLive(rng, numCommitted)
+		if i < 0 {
+			break
+		}
+		from, _ := r.currentPath(i)
+		to := fmt.Sprintf("%s_r%d", from, commit)
+		r.opFiles = 1
+		res, err := r.backend.Move(r, from, to)
+		if err == nil {
+			r.moved[r.Worktree.Files[i]] = to
+			r.renamed++
+		}
+		if !timed(res, err) {
+			return elapsed, corrected, firstErr
+		}
+	}
+
+	// Whole directories, renamed within their parent
+	for n := ratePerCommit(r.DirMoveRate, commit); n > 0; n-- {
+		from := r.pickMovableDir(rng, numCommitted)
+		if from == "" {
+			break
+		}
+		to := fmt.Sprintf("%s_m%d", from, commit)
+		files := r.dirs.filesUnder(r, from)
+		r.opFiles = len(files)
+		res, err := r.backend.Move(r, from, to)
+		if err == nil {
+			for _, i := range files {
+				p, _ := r.currentPath(i)
+				r.moved[r.Worktree.Files[i]] = to + p[len(from):]
+			}
+			r.dirs.rename(from, to)
+			r.movedDirs++
+		}
+		if !timed(res, err) {
+			return elapsed, corrected, firstErr
+		}
+	}
+
+	return elapsed, corrected, firstErr
+}
+
+// dirIndex keeps track of which files are in which directories, and
+// which directories have files still to be committed, so that a
+// directory move only looks at the files it moves. It is made the first
+// time a directory moves, and kept up to date after that.
+type dirIndex struct {
+	// files lists the worktree files directly in each directory;
+	// deleted files aren't taken out, but currentPath knows them
+	files map[string][]int
+
+	// subdirs lists the directories directly in each directory
+	subdirs map[string][]string
+
+	// pending counts the files in or below each directory that haven't
+	// been committed (they can't move or be deleted, so they stay where
+	// the worktree put them)
+	pending map[string]int
+
+	// numCommitted is how many worktree files pending allows for
+	numCommitted int
+}
+
+// newDirIndex indexes where every worktree file is now
+func newDirIndex(r *Repo, numCommitted int) *dirIndex {
+	d := &dirIndex{files: make(map[string][]int), subdirs: make(map[string][]string),
+		pending: make(map[string]int), numCommitted: numCommitted}
+	for i := range r.Worktree.Files {
+		p, ok := r.currentPath(i)
+		if !ok {
+			continue
+		}
+		dir := path.Dir(p)
+		d.addDir(dir)
+		d.files[dir] = append(d.files[dir], i)
+		if i >= numCommitted {
+			d.addPending(p, 1)
+		}
+	}
+	return d
+}
+
+// addDir adds dir, and any parents that aren't there yet
+func (d *dirIndex) addDir(dir string) {
+	if _, known := d.files[dir]; known {
+		return
+	}
+	d.files[dir] = nil
+	if dir != "." {
+		parent := path.Dir(dir)
+		d.addDir(parent)
+		d.subdirs[parent] = append(d.subdirs[parent], dir)
+	}
+}
+
+// addPending adds n to the pending count of each directory above p
+func (d *dirIndex) addPending(p string, n int) {
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		d.pending[dir] += n
+	}
+}
+
+// commit notes that the first numCommitted worktree files are committed
+func (d *dirIndex) commit(r *Repo, numCommitted int) {
+	for i := d.numCommitted; i < numCommitted; i++ {
+		d.addPending(r.Worktree.Files[i], -1)
+	}
+	d.numCommitted = numCommitted
+}
+
+// filesUnder lists the files in or below dir that haven't been deleted
+func (d *dirIndex) filesUnder(r *Repo, dir string) []int {
+	var files []int
+	for _, i := range d.files[dir] {
+		if _, ok := r.currentPath(i); ok {
+			files = append(files, i)
+		}
+	}
+	for _, sub := range d.subdirs[dir] {
+		files = append(files, d.filesUnder(r, sub)...)
+	}
+	return files
+}
+
+// rename moves the index entries for from and everything below it to
+// to (in the same parent)
+func (d *dirIndex) rename(from string, to string) {
+	parent := d.subdirs[path.Dir(from)]
+	for k, sub := range parent {
+		if sub == from {
+			parent[k] = to
+		}
+	}
+
+	var move func(from string, to string)
+	move = func(from string, to string) {
+		d.files[to] = d.files[from]
+		d.pending[to] = d.pending[from]
+		delete(d.files, from)
+		delete(d.pending, from)
+		subs := d.subdirs[from]
+		delete(d.subdirs, from)
+		for k, sub := range subs {
+			subs[k] = to + sub[len(from):]
+			move(sub, subs[k])
+		}
+		if subs != nil {
+			d.subdirs[to] = subs
+		}
+	}
+	move(from, to)
+}
+
+// pickMovableDir chooses a directory that can be moved: one that only
+// holds committed files (so nothing waiting to be added moves with
+// it). It looks above a few committed files, and returns "" if none of
+// them are in one.
+func (r *Repo) pickMovableDir(rng *rand.Rand, numCommitted int) string {
+	if r.dirs == nil {
+		r.dirs = newDirIndex(r, numCommitted)
+	}
+	r.dirs.commit(r, numCommitted)
+
+	for try := 0; try < 10; try++ {
+		i := r.pickLive(rng, numCommitted)
+		if i < 0 {
+			return ""
+		}
+		p, _ := r.currentPath(i)
+		var dirs []string
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if r.dirs.pending[dir] == 0 {
+				dirs = append(dirs, dir)
+			}
+		}
+		if len(dirs) > 0 {
+			return dirs[rng.Intn(len(dirs))]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+// vcs-torture/vcs/moves_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"math/rand"
+	"reflect"
+)
+
+func TestRatePerCommit(t *testing.T) {
+	tests := []struct {
+		rate float64
+		want []int
+	}{
+		{0, []int{0, 0, 0, 0}},
+		{2, []int{2, 2, 2, 2}},
+		{0.25, []int{0, 0, 0, 1}},
+		{1.5, []int{1, 2, 1, 2}},
+	}
+	for _, test := range tests {
+		var got []int
+		for commit := 1; commit <= 4; commit++ {
+			got = append(got, ratePerCommit(test.rate, commit))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("rate %g gives %v, expected %v", test.rate, got, test.want)
+		}
+	}
+}
+
+// TestDirIndex makes sure that the directory index keeps up with
+// deletes, directory moves and commits
+func TestDirIndex(t *testing.T) {
+	r := &Repo{moved: map[string]string{}, Worktree: &Worktree{
+		Files: []string{"a/f0", "a/b/f1", "a/b/f2", "c/f3", "c/d/f4", "f5"},
+	}}
+	r.dirs = newDirIndex(r, 4)
+
+	if got := r.dirs.filesUnder(r, "a"); !reflect.DeepEqual(got, []int{0, 1, 2}) {
+		t.Errorf("a holds %v, expected [0 1 2]", got)
+	}
+	if r.dirs.pending["a"] != 0 || r.dirs.pending["c"] != 1 || r.dirs.pending["c/d"] != 1 {
+		t.Errorf("pending is %v, expected c and c/d to have one file", r.dirs.pending)
+	}
+
+	// Delete a file, then move the directory it was in
+	r.moved["a/b/f1"] = ""
+	for _, i := range r.dirs.filesUnder(r, "a") {
+		p, _ := r.currentPath(i)
+		r.moved[r.Worktree.Files[i]] = "a_m1" + p[len("a"):]
+	}
+	r.dirs.rename("a", "a_m1")
+	if got := r.dirs.filesUnder(r, "a_m1"); !reflect.DeepEqual(got, []int{0, 2}) {
+		t.Errorf("a_m1 holds %v, expected [0 2]", got)
+	}
+	if got := r.dirs.filesUnder(r, "a/b"); got != nil {
+		t.Errorf("a/b still holds %v", got)
+	}
+	if got := r.dirs.subdirs["a_m1"]; !reflect.DeepEqual(got, []string{"a_m1/b"}) {
+		t.Errorf("a_m1 has subdirectories %v, expected [a_m1/b]", got)
+	}
+
+	// Committing the rest leaves nothing pending, which is what a new
+	// index finds too
+	r.dirs.commit(r, 6)
+	fresh := newDirIndex(r, 6)
+	for _, dir := range []string{"a_m1", "a_m1/b", "c", "c/d"} {
+		if r.dirs.pending[dir] != 0 || fresh.pending[dir] != 0 {
+			t.Errorf("%s has %d files pending (%d in a new index)", dir, r.dirs.pending[dir], fresh.pending[dir])
+		}
+		if got, want := r.dirs.filesUnder(r, dir), fresh.filesUnder(r, dir); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s holds %v, but a new index says %v", dir, got, want)
+		}
+	}
+}
+
+// TestPickMovableDir makes sure that directories with files still to
+// be committed aren't picked
+func TestPickMovableDir(t *testing.T) {
+	r := &Repo{moved: map[string]string{}, Worktree: &Worktree{
+		Files: []string{"a/f0", "a/b/f1", "c/f2", "c/d/f3", "c/f4"},
+	}}
+	rng := rand.New(rand.NewSource(1))
+	for try := 0; try < 100; try++ {
+		if dir := r.pickMovableDir(rng, 4); dir != "a" && dir != "a/b" && dir != "c/d" {
+			t.Fatalf("picked %q, expected a, a/b or c/d", dir)
+		}
+	}
+	if dir := r.pickMovableDir(rng, 0); dir != "" {
+		t.Errorf("picked %q with nothing committed", dir)
+	}
+}
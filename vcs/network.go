@@ -0,0 +1,158 @@
+// vcs-torture/vcs/network.go
+
+package vcs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// NetworkStats describes one network run
+type NetworkStats struct {
+	Protocol string
+	URL      string
+
+	CloneTime float64
+	PushTime  float64
+	FetchTime float64
+}
+
+// pushPath is the directory in a clone that a network run's new
+// files go in
+const pushPath = "torture-push"
+
+// Network serves the repo on localhost over r.Protocol (through a
+// Proxy if r.Impairment or r.Proxy ask for one) and times talking to
+// it: two clones are made from the server, new files are committed in
+// one and pushed to the server on a new branch, and the other fetches
+// that branch. The main line of the repo isn't changed.
+// The server is always stopped before returning.
+func (r *Repo) Network() (*NetworkStats, error) {
+	if r.backend == nil {
+		return nil, fmt.Errorf("Unknown version control system: %s", r.vcs)
+	}
+	if _, err := os.Stat(r.repo); err != nil {
+		return nil, err
+	}
+
+	protocols := r.backend.ServeProtocols()
+	protocol := r.Protocol
+	if protocol == "" {
+		protocol = protocols[0]
+	}
+	known := false
+	for _, p := range protocols {
+		known = known || p == protocol
+	}
+	if !known {
+		return nil, fmt.Errorf("%s can't be served over %s (use one of: %s)", r.vcs, protocol, strings.Join(protocols, ", "))
+	}
+
+	pushDir := r.cloneDir("net-" + protocol + "-push")
+	fetchDir := r.cloneDir("net-" + protocol + "-fetch")
+	for _, dir := range []string{pushDir, fetchDir} {
+		if err := r.freshClone(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.calibrate(r.Calibrate); err != nil {
+		return nil, err
+	}
+	numCommits, err := r.backend.NumCommits(r)
+	if err != nil {
+		return nil, err
+	}
+	r.commit = numCommits
+	r.protocol = protocol
+	defer func() { r.protocol = "" }()
+
+	server, err := r.backend.Serve(r, protocol)
+	if err != nil {
+		return nil, fmt.Errorf("serving %s over %s: %s", r.repo, protocol, err)
+	}
+	defer server.Stop()
+
+	url := server.URL
+	if r.Proxy || r.Impairment != (Impairment{}) {
+		if server.Addr == "" {
+			return nil, fmt.Errorf("%s can't go through a proxy", protocol)
+		}
+		proxy, err := StartProxy(server.Addr, r.Impairment)
+		if err != nil {
+			return nil, err
+		}
+		defer proxy.Close()
+		url = strings.Replace(url, server.Addr, proxy.Addr, 1)
+		r.impairment = &r.Impairment
+		defer func() { r.impairment = nil }()
+	}
+	if r.verbose {
+		fmt.Printf("Serving %s at %s\n", r.repo, url)
+	}
+
+	stats := &NetworkStats{Protocol: protocol, URL: url}
+	for _, dir := range []string{pushDir, fetchDir} {
+		res, err := r.backend.RemoteClone(r, url, dir)
+		if err != nil {
+			return nil, err
+		}
+		if dir == pushDir {
+			stats.CloneTime = res.Elapsed
+		}
+	}
+
+	// New files on a branch of their own, which leaves the main line
+	// alone; the time keeps branches from different runs apart
+	branch := BranchPrefix + "push-" + time.Now().Format("20060102-150405.000")
+	if err := writePushFiles(filepath.Join(pushDir, pushPath), r.PushFiles, r.PushFileSize, r.Seed); err != nil {
+		return nil, err
+	}
+	r.opFiles = r.PushFiles
+
+	if r.aborted() {
+		return nil, fmt.Errorf("%w before pushing", ErrAborted)
+	}
+	res, err := r.backend.RemotePush(r, url, pushDir, branch, pushPath)
+	if err != nil {
+		return nil, err
+	}
+	stats.PushTime = res.Elapsed
+
+	if r.aborted() {
+		return nil, fmt.Errorf("%w before fetching", ErrAborted)
+	}
+	res, err = r.backend.RemoteFetch(r, url, fetchDir, branch)
+	if err != nil {
+		return nil, err
+	}
+	stats.FetchTime = res.Elapsed
+
+	rec := &Result{Op: "net-summary", Elapsed: stats.CloneTime + stats.PushTime + stats.FetchTime,
+		CloneTime: stats.CloneTime, PushTime: stats.PushTime, FetchTime: stats.FetchTime}
+	if r.overhead > 0 {
+		rec.Overhead = r.overhead
+		rec.Corrected = r.corrected(stats.CloneTime) + r.corrected(stats.PushTime) + r.corrected(stats.FetchTime)
+	}
+	r.record(rec)
+	return stats, nil
+}
+
+// writePushFiles makes num new files of the given size in dir
+func writePushFiles(dir string, num int, size int64, seed int64) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	for i := 0; i < num; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("f%06d.txt", i))
+		write := func(out io.Writer) error { return writeSeededContent(out, seed, i, size) }
+		if _, err := writeContentFile(path, write); err != nil {
+			return err
+		}
+	}
+	return nil
+}
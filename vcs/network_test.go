@@ -0,0 +1,135 @@
+// vcs-torture/vcs/network_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+func TestFileURL(t *testing.T) {
+	url := fileURL("repo")
+	if !strings.HasPrefix(url, "file:///") || strings.HasPrefix(url, "file:////") ||
+		!strings.HasSuffix(url, "/repo") || strings.Contains(url, `\`) {
+		t.Errorf("fileURL(%q) = %q", "repo", url)
+	}
+}
+
+func TestWritePushFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	push := filepath.Join(dir, pushPath)
+	if err := writePushFiles(push, 3, 500, 42); err != nil {
+		t.Fatalf("writePushFiles: %s", err)
+	}
+	files, _ := ioutil.ReadDir(push)
+	if len(files) != 3 {
+		t.Fatalf("writePushFiles made %d files, expected 3", len(files))
+	}
+	for i, f := range files {
+		content, err := ioutil.ReadFile(filepath.Join(push, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want bytes.Buffer
+		writeSeededContent(&want, 42, i, 500)
+		if f.Name() != fmt.Sprintf("f%06d.txt", i) || !bytes.Equal(content, want.Bytes()) {
+			t.Errorf("push file %d is %s, with the wrong content", i, f.Name())
+		}
+	}
+}
+
+// TestStartServer makes sure that a server that doesn't listen is
+// noticed
+func TestStartServer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	port, err := freePort()
+	if err != nil {
+		t.Fatalf("freePort: %s", err)
+	}
+	s, err := startServer(&Server{}, "test", port, "", "sh", "-c", "echo no >&2; exit 2")
+	if err == nil || !strings.Contains(err.Error(), "exited before listening") || !strings.Contains(err.Error(), "no") {
+		t.Errorf("startServer = %v, %v, expected it to fail", s, err)
+	}
+
+	var none *Server
+	if none.Stop() != nil {
+		t.Errorf("stopping a nil Server failed")
+	}
+}
+
+// TestNetwork clones, pushes and fetches a small git repo over each
+// protocol (all of them need git, and the git protocol needs git daemon)
+func TestNetwork(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skip network test in short mode")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("needs git")
+	}
+	for _, v := range []string{"GIT_AUTHOR_NAME", "GIT_AUTHOR_EMAIL", "GIT_COMMITTER_NAME", "GIT_COMMITTER_EMAIL"} {
+		old, ok := os.LookupEnv(v)
+		os.Setenv(v, "vcs-torture")
+		if ok {
+			defer os.Setenv(v, old)
+		} else {
+			defer os.Unsetenv(v)
+		}
+	}
+	dest, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	options := RepoOptions{NumCommits: 3, AddsPerCommit: 1, FilesPerAdd: 10, PushFiles: 5, PushFileSize: 1000}
+	r := NewRepo(dest, "repo", "git", time.Now(), options)
+	if err := r.Create(); err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	r.AddWorktree(WorktreeOptions{NumFiles: 30, FileSize: 200})
+	r.Worktree.Generate(nil)
+	if err := r.Commit(nil); err != nil {
+		t.Fatalf("Commit: %s", err)
+	}
+
+	for _, protocol := range []string{"file", "http", "git"} {
+		r.Protocol = protocol
+		stats, err := r.Network()
+		if err != nil {
+			t.Errorf("%s: %s", protocol, err)
+			continue
+		}
+		if stats.Protocol != protocol || stats.CloneTime <= 0 || stats.PushTime <= 0 || stats.FetchTime <= 0 {
+			t.Errorf("%s: network run is %+v", protocol, stats)
+		}
+		if files, _ := ioutil.ReadDir(filepath.Join(r.cloneDir("net-"+protocol+"-push"), pushPath)); len(files) != 5 {
+			t.Errorf("%s: %d files pushed, expected 5", protocol, len(files))
+		}
+	}
+
+	// The main line is left alone
+	if n, err := r.backend.NumCommits(r); n != 3 || err != nil {
+		t.Errorf("after network runs, NumCommits = %d, %v", n, err)
+	}
+
+	r.Protocol = "ftp"
+	if _, err := r.Network(); err == nil {
+		t.Errorf("network run over ftp worked")
+	}
+}
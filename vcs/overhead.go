@@ -0,0 +1,64 @@
+// vcs-torture/vcs/overhead.go
+
+package vcs
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Overhead is the cost of just starting a version control command,
+// measured by timing a trivial command (e.g. "git --version") several
+// times. This matters for small operations on tools that are slow to
+// start: an "hg add" of one file is mostly Python starting up.
+type Overhead struct {
+	Runs   int
+	Median float64
+	Min    float64
+	Max    float64
+}
+
+// calibrate measures the startup overhead for this repo's backend,
+// and records it in the results. From then on, add and commit
+// results carry overhead-corrected times as well as raw ones.
+func (r *Repo) calibrate(runs int) error {
+	if runs <= 0 {
+		return nil
+	}
+
+	argv := r.backend.VersionCommand()
+	times := make([]float64, 0, runs)
+	for i := 0; i < runs; i++ {
+		res, err := RunExternal(argv[0], r.dest, nil, argv[1:]...)
+		if err != nil {
+			return fmt.Errorf("calibrating: %s", err)
+		}
+		times = append(times, res.Elapsed)
+	}
+	sort.Float64s(times)
+
+	oh := Overhead{Runs: runs, Min: times[0], Max: times[runs-1]}
+	if runs%2 == 1 {
+		oh.Median = times[runs/2]
+	} else {
+		oh.Median = (times[runs/2-1] + times[runs/2]) / 2
+	}
+	r.calibration = oh
+	r.overhead = oh.Median
+
+	if r.verbose {
+		fmt.Printf("Startup overhead for %s: median %.4f (min %.4f, max %.4f) over %d runs\n",
+			argv[0], oh.Median, oh.Min, oh.Max, oh.Runs)
+	}
+	r.record(&Result{Op: "overhead", Command: argv[0] + " " + argv[1], Elapsed: oh.Median,
+		Overhead: oh.Median, OverheadMin: oh.Min, OverheadMax: oh.Max, OverheadRuns: oh.Runs})
+	return nil
+}
+
+// corrected removes the startup overhead from an elapsed time
+func (r *Repo) corrected(elapsed float64) float64 {
+	if elapsed < r.overhead {
+		return 0
+	}
+	return elapsed - r.overhead
+}
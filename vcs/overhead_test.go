@@ -0,0 +1,91 @@
+// vcs-torture/vcs/overhead_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func (b *fakeBackend) VersionCommand() []string {
+	return b.version
+}
+
+func TestCorrected(t *testing.T) {
+	tests := []struct {
+		overhead float64
+		elapsed  float64
+		want     float64
+	}{
+		{0, 0, 0},
+		{0, 0.5, 0.5},
+		{0.25, 0.5, 0.25},
+		{0.25, 0.25, 0},
+		{0.25, 0.125, 0},
+	}
+	for _, test := range tests {
+		r := &Repo{overhead: test.overhead}
+		if got := r.corrected(test.elapsed); got != test.want {
+			t.Errorf("corrected(%g) with overhead %g = %g, expected %g", test.elapsed, test.overhead, got, test.want)
+		}
+	}
+}
+
+// TestCalibrate times the test binary doing nothing, and makes sure
+// that the overhead is recorded and taken off later commands
+func TestCalibrate(t *testing.T) {
+	dest, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	path := filepath.Join(dest, "results.ndjson")
+	results, err := OpenResults(path, "run")
+	if err != nil {
+		t.Fatalf("OpenResults: %s", err)
+	}
+	b := &fakeBackend{version: []string{os.Args[0], "-test.run=^$"}}
+	r := NewRepo(dest, "repo", "fake", time.Now(), RepoOptions{})
+	r.backend = b
+	r.SetResults(results)
+
+	// No runs, no calibration
+	if err := r.calibrate(0); err != nil || r.overhead != 0 || r.calibration.Runs != 0 {
+		t.Errorf("calibrate(0) = %v, with overhead %g", err, r.overhead)
+	}
+
+	if err := r.calibrate(4); err != nil {
+		t.Fatalf("calibrate: %s", err)
+	}
+	oh := r.calibration
+	if oh.Runs != 4 || oh.Min <= 0 || oh.Min > oh.Median || oh.Median > oh.Max || r.overhead != oh.Median {
+		t.Errorf("calibration is %+v, overhead %g", oh, r.overhead)
+	}
+	if _, err := r.run("add", os.Args[0], dest, "-test.run=^$"); err != nil {
+		t.Fatalf("run: %s", err)
+	}
+	results.Close()
+
+	records, _ := readResults(t, path)
+	if len(records) != 2 {
+		t.Fatalf("%d records, expected 2", len(records))
+	}
+	rec := records[0]
+	if rec.Op != "overhead" || rec.OverheadRuns != 4 || rec.Overhead != oh.Median || rec.OverheadMin != oh.Min || rec.OverheadMax != oh.Max {
+		t.Errorf("overhead record is %+v", rec)
+	}
+	rec = records[1]
+	if rec.Op != "add" || rec.Overhead != oh.Median || rec.Corrected != r.corrected(rec.Elapsed) {
+		t.Errorf("add record is %+v", rec)
+	}
+
+	b.version = []string{filepath.Join(dest, "missing"), "--version"}
+	if err := r.calibrate(2); err == nil {
+		t.Errorf("calibrate with a missing command worked")
+	}
+}
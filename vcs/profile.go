@@ -0,0 +1,431 @@
+// vcs-torture/vcs/profile.go
+
+package vcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/bits"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A Profile is the shape of a real directory tree: how big its files
+// are, how deep and wide its directories go, which extensions its files
+// have and how long their names are. It has no names or content, so it
+// can be taken from a private checkout and handed around; Generate then
+// makes a tree of any size with the same shape.
+type Profile struct {
+	Files int   `json:"files"`
+	Dirs  int   `json:"dirs"`
+	Bytes int64 `json:"bytes"`
+
+	// Sizes is file sizes in bytes
+	Sizes Histogram `json:"sizes"`
+
+	// Levels describes the directories at each depth, the top first
+	Levels []ProfileLevel `json:"levels"`
+
+	// Extensions are the commonest extensions (with the dot), and ""
+	// for none; their percentages add up to 100
+	Extensions []ExtensionShare `json:"extensions"`
+
+	// NameLengths is the length of file names without the extension,
+	// and DirNameLengths of directory names
+	NameLengths    Histogram `json:"name_lengths"`
+	DirNameLengths Histogram `json:"dir_name_lengths"`
+
+	path  string
+	hash  uint32
+	sizes *SizeDist
+}
+
+// ProfileLevel is what the directories at one depth hold
+type ProfileLevel struct {
+	Dirs    int       `json:"dirs"`
+	Files   Histogram `json:"files"`
+	Subdirs Histogram `json:"subdirs"`
+}
+
+// ExtensionShare is a percentage of files with one extension
+type ExtensionShare struct {
+	Ext     string  `json:"ext"`
+	Percent float64 `json:"percent"`
+}
+
+// ProfileBucket is one bar of a histogram: Percent of the values are
+// from Min to Max
+type ProfileBucket struct {
+	Min     int64   `json:"min"`
+	Max     int64   `json:"max"`
+	Percent float64 `json:"percent"`
+}
+
+// Histogram is a distribution of values; small values have a bar each,
+// bigger ones a bar per power of 2
+type Histogram []ProfileBucket
+
+// maxExtensions is how many extensions a profile keeps
+const maxExtensions = 40
+
+// maxExtLength is the longest extension (with the dot) a profile
+// keeps; longer ones (and ones with odd characters) count as none
+const maxExtLength = 16
+
+// vcsDirs are the directories that ScanProfile leaves out
+var vcsDirs = map[string]bool{".git": true, ".hg": true, ".svn": true}
+
+// profileScan counts things up as ScanProfile goes
+type profileScan struct {
+	p              *Profile
+	sizes          map[int64]int
+	files          []map[int64]int
+	subdirs        []map[int64]int
+	exts           map[string]int
+	nameLengths    map[int64]int
+	dirNameLengths map[int64]int
+}
+
+// ScanProfile works out the profile of the tree at root. Symbolic
+// links are passed over, and so are version control directories and
+// directories with no files anywhere under them (which version control
+// doesn't keep).
+func ScanProfile(root string) (*Profile, error) {
+	s := &profileScan{
+		p:              &Profile{},
+		sizes:          make(map[int64]int),
+		exts:           make(map[string]int),
+		nameLengths:    make(map[int64]int),
+		dirNameLengths: make(map[int64]int),
+	}
+	if _, err := s.scanDir(root, 0); err != nil {
+		return nil, err
+	}
+	p := s.p
+	if p.Files == 0 {
+		return nil, fmt.Errorf("no files in %s", root)
+	}
+
+	p.Sizes = newHistogram(s.sizes)
+	p.NameLengths = newHistogram(s.nameLengths)
+	p.DirNameLengths = newHistogram(s.dirNameLengths)
+	for depth := range s.files {
+		level := ProfileLevel{Files: newHistogram(s.files[depth]), Subdirs: newHistogram(s.subdirs[depth])}
+		for _, n := range s.files[depth] {
+			level.Dirs += n
+		}
+		p.Levels = append(p.Levels, level)
+	}
+
+	// Keep the commonest extensions, sharing the rest out between them
+	for ext, n := range s.exts {
+		p.Extensions = append(p.Extensions, ExtensionShare{Ext: ext, Percent: float64(n)})
+	}
+	sort.Slice(p.Extensions, func(i, j int) bool {
+		a, b := p.Extensions[i], p.Extensions[j]
+		return a.Percent > b.Percent || (a.Percent == b.Percent && a.Ext < b.Ext)
+	})
+	if len(p.Extensions) > maxExtensions {
+		p.Extensions = p.Extensions[:maxExtensions]
+	}
+	kept := 0.0
+	for _, share := range p.Extensions {
+		kept += share.Percent
+	}
+	for i := range p.Extensions {
+		p.Extensions[i].Percent *= 100 / kept
+	}
+	return p, nil
+}
+
+// scanDir adds dir (at depth) to the profile, returning how many files
+// are in and under it
+func (s *profileScan) scanDir(dir string, depth int) (int, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var files, subdirs, total int
+	var sizes, nameLengths, dirNameLengths []int64
+	var exts []string
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case entry.IsDir():
+			if vcsDirs[name] {
+				continue
+			}
+			n, err := s.scanDir(filepath.Join(dir, name), depth+1)
+			if err != nil {
+				return 0, err
+			}
+			if n > 0 {
+				subdirs++
+				total += n
+				dirNameLengths = append(dirNameLengths, int64(len(name)))
+			}
+
+		case entry.Mode().IsRegular():
+			ext := filepath.Ext(name)
+			if !goodExtension(ext) {
+				ext = ""
+			}
+			files++
+			sizes = append(sizes, entry.Size())
+			exts = append(exts, ext)
+			nameLengths = append(nameLengths, int64(len(name)-len(ext)))
+		}
+	}
+	total += files
+	if total == 0 && depth > 0 {
+		return 0, nil
+	}
+
+	p := s.p
+	p.Dirs++
+	p.Files += files
+	for i, size := range sizes {
+		p.Bytes += size
+		s.sizes[size]++
+		s.exts[exts[i]]++
+		s.nameLengths[nameLengths[i]]++
+	}
+	for _, length := range dirNameLengths {
+		s.dirNameLengths[length]++
+	}
+	for len(s.files) <= depth {
+		s.files = append(s.files, make(map[int64]int))
+		s.subdirs = append(s.subdirs, make(map[int64]int))
+	}
+	s.files[depth][int64(files)]++
+	s.subdirs[depth][int64(subdirs)]++
+	return total, nil
+}
+
+// goodExtension is whether ext is short and plain enough to keep
+func goodExtension(ext string) bool {
+	if len(ext) < 2 || len(ext) > maxExtLength {
+		return false
+	}
+	for _, c := range ext[1:] {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("_+-~", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// newHistogram makes a histogram from how many times each value was
+// seen
+func newHistogram(counts map[int64]int) Histogram {
+	total := 0
+	bars := make(map[int64]*ProfileBucket)
+	for value, n := range counts {
+		total += n
+		min, max := value, value
+		if value >= 16 {
+			k := uint(bits.Len64(uint64(value)) - 1)
+			min, max = int64(1)<<k, int64(1)<<(k+1)-1
+		}
+		if bars[min] == nil {
+			bars[min] = &ProfileBucket{Min: min, Max: max}
+		}
+		bars[min].Percent += float64(n)
+	}
+
+	var h Histogram
+	for _, bar := range bars {
+		bar.Percent *= 100 / float64(total)
+		h = append(h, *bar)
+	}
+	sort.Slice(h, func(i, j int) bool { return h[i].Min < h[j].Min })
+	return h
+}
+
+// pick picks a bar by its percentage, then any value in it
+func (h Histogram) pick(rng *rand.Rand) int64 {
+	if len(h) == 0 {
+		return 0
+	}
+	point := rng.Float64() * 100
+	bar := h[len(h)-1]
+	for _, b := range h {
+		if point < b.Percent {
+			bar = b
+			break
+		}
+		point -= b.Percent
+	}
+	return randBetween(rng, bar.Min, bar.Max)
+}
+
+// Save writes the profile to path, as JSON
+func (p *Profile) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// LoadProfile reads a profile that Save wrote
+func LoadProfile(path string) (*Profile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Profile{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if p.Files == 0 || len(p.Levels) == 0 || len(p.Sizes) == 0 {
+		return nil, fmt.Errorf("%s: not a profile, or an empty one", path)
+	}
+	for _, h := range append([]Histogram{p.Sizes, p.NameLengths, p.DirNameLengths}, p.levelHistograms()...) {
+		for _, b := range h {
+			if b.Min < 0 || b.Max < b.Min || b.Percent < 0 {
+				return nil, fmt.Errorf("%s: bad histogram bar %d-%d", path, b.Min, b.Max)
+			}
+		}
+	}
+
+	p.path = path
+	p.hash = contentHash(data)
+	p.sizes = &SizeDist{Kind: SizeHistogram, spec: "profile"}
+	for _, b := range p.Sizes {
+		p.sizes.Buckets = append(p.sizes.Buckets, SizeBucket{Min: b.Min, Max: b.Max, Percent: b.Percent})
+	}
+	return p, nil
+}
+
+func (p *Profile) levelHistograms() []Histogram {
+	var hs []Histogram
+	for _, level := range p.Levels {
+		hs = append(hs, level.Files, level.Subdirs)
+	}
+	return hs
+}
+
+// String is the path the profile was loaded from
+func (p *Profile) String() string {
+	return p.path
+}
+
+// Depth is how many levels of directories the profile has
+func (p *Profile) Depth() int {
+	return len(p.Levels)
+}
+
+// Streams for the choices Generate makes from a profile
+const (
+	profileDirStream  = 0x3d15
+	profileFileStream = 0x3f11
+)
+
+// profileDirs is how far Generate has got in laying out directories
+// from a profile: the directory it's filling and how many more files
+// that takes, then the directories still to fill, top down
+type profileDirs struct {
+	dir    string
+	left   int
+	queue  []profileDir
+	filled int
+	named  int
+	passes int
+}
+
+type profileDir struct {
+	path  string
+	depth int
+}
+
+// profileDir is the directory for the next file. Each directory's files
+// and subdirectories are picked from the histograms for its depth (so
+// they depend only on the seed and how many directories came before),
+// and directories are filled top down, a level at a time. When all the
+// directories the profile has room for are full, more are started at
+// the top, so a bigger tree is wider but no deeper.
+func (w *Worktree) profileDir() string {
+	p := &w.pdirs
+	levels := w.Profile.Levels
+	for p.left == 0 {
+		if len(p.queue) == 0 {
+			p.queue = append(p.queue, profileDir{})
+			p.passes++
+		}
+		d := p.queue[0]
+		p.queue = p.queue[1:]
+
+		rng := seededRand(w.Seed^profileDirStream, int64(p.filled))
+		p.filled++
+		files := int(levels[d.depth].Files.pick(rng))
+		subdirs := int(levels[d.depth].Subdirs.pick(rng))
+		for tries := 0; files == 0 && subdirs == 0 && tries < 100; tries++ {
+			// An empty directory wouldn't be kept, so pick again
+			files = int(levels[d.depth].Files.pick(rng))
+			subdirs = int(levels[d.depth].Subdirs.pick(rng))
+		}
+		if d.depth == 0 && p.passes > 1 && len(levels) > 1 {
+			files = 0
+		}
+		for i := 0; i < subdirs && d.depth+1 < len(levels); i++ {
+			// Directory names end in y, which file names never have
+			name := padName(uniqueName(p.named)+"y", w.Profile.DirNameLengths.pick(rng), 'y')
+			p.named++
+			if d.path != "" {
+				name = d.path + "/" + name
+			}
+			p.queue = append(p.queue, profileDir{path: name, depth: d.depth + 1})
+		}
+		p.dir, p.left = d.path, files
+	}
+	p.left--
+	return p.dir
+}
+
+// profileName is the name of file nth, with a length and extension
+// picked from the profile. It starts with uniqueName(nth) to keep it
+// unique, and is padded with z (which uniqueName never has).
+func (w *Worktree) profileName(nth int) string {
+	rng := seededRand(w.Seed^profileFileStream, int64(nth))
+	name := padName(uniqueName(nth), w.Profile.NameLengths.pick(rng), 'z')
+
+	point := rng.Float64() * 100
+	for _, share := range w.Profile.Extensions {
+		if point < share.Percent {
+			return name + share.Ext
+		}
+		point -= share.Percent
+	}
+	return name
+}
+
+// padName pads name with c to length (names already that long are left
+// alone)
+func padName(name string, length int64, c byte) string {
+	if n := int(length) - len(name); n > 0 {
+		return name + strings.Repeat(string(c), n)
+	}
+	return name
+}
+
+// Summary describes a profile in a few lines
+func (p *Profile) Summary() string {
+	var exts []string
+	for i, share := range p.Extensions {
+		if i == 10 {
+			break
+		}
+		ext := share.Ext
+		if ext == "" {
+			ext = "(none)"
+		}
+		exts = append(exts, fmt.Sprintf("%s %.1f%%", ext, share.Percent))
+	}
+	return fmt.Sprintf("%d files, %d directories, %d bytes, %d levels deep\nExtensions: %s\n",
+		p.Files, p.Dirs, p.Bytes, p.Depth(), strings.Join(exts, ", "))
+}
@@ -0,0 +1,61 @@
+// vcs-torture/vcs/profile_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"math"
+	"math/rand"
+	"reflect"
+)
+
+func TestNewHistogram(t *testing.T) {
+	tests := []struct {
+		counts map[int64]int
+		want   Histogram
+	}{
+		{map[int64]int{}, nil},
+		{map[int64]int{5: 3}, Histogram{{5, 5, 100}}},
+		{
+			map[int64]int{0: 1, 3: 1, 16: 1, 20: 1, 31: 1, 32: 1, 1000: 4},
+			Histogram{{0, 0, 10}, {3, 3, 10}, {16, 31, 30}, {32, 63, 10}, {512, 1023, 40}},
+		},
+	}
+	for _, test := range tests {
+		if got := newHistogram(test.counts); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("newHistogram(%v) = %v, expected %v", test.counts, got, test.want)
+		}
+	}
+}
+
+// TestHistogramPick makes sure that picked values are in a bar, and that
+// each bar gets about its share of them
+func TestHistogramPick(t *testing.T) {
+	h := Histogram{{0, 0, 10}, {3, 3, 10}, {16, 31, 30}, {32, 63, 10}, {512, math.MaxInt64, 40}}
+	rng := rand.New(rand.NewSource(1))
+	counts := make([]int, len(h))
+	for i := 0; i < 10000; i++ {
+		value := h.pick(rng)
+		found := false
+		for b, bar := range h {
+			if value >= bar.Min && value <= bar.Max {
+				counts[b]++
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("picked %d, which isn't in a bar", value)
+		}
+	}
+	for b, bar := range h {
+		want := int(bar.Percent * 100)
+		if counts[b] < want*9/10 || counts[b] > want*11/10 {
+			t.Errorf("bar %d-%d got %d of 10000 values, expected about %d", bar.Min, bar.Max, counts[b], want)
+		}
+	}
+
+	if value := Histogram(nil).pick(rng); value != 0 {
+		t.Errorf("an empty histogram picked %d", value)
+	}
+}
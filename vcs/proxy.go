@@ -0,0 +1,216 @@
+// vcs-torture/vcs/proxy.go
+
+package vcs
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Impairment describes how bad a simulated network is. The zero
+// value is a perfect network.
+type Impairment struct {
+	// Latency is the round-trip time; each direction is delayed by
+	// half of it, plus or minus up to Jitter (data is never reordered)
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// Bandwidth is in bytes per second in each direction (0 means
+	// unlimited)
+	Bandwidth int64
+
+	// ChunkSize splits data into pieces of at most this many bytes,
+	// each of which is delayed and written separately (0 means data
+	// goes on as it was read)
+	ChunkSize int
+}
+
+// Proxy forwards TCP connections to a server, through an Impairment
+type Proxy struct {
+	Addr string
+
+	target string
+	imp    Impairment
+	l      net.Listener
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	conns   map[net.Conn]bool
+	closing bool
+	wg      sync.WaitGroup
+}
+
+// proxyQueue is how many chunks can be in flight in each direction
+// before the proxy stops reading
+const proxyQueue = 1024
+
+// StartProxy listens on localhost and forwards every connection to
+// target. Jitter is pseudo-random but the same from run to run.
+func StartProxy(target string, imp Impairment) (*Proxy, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Proxy{Addr: l.Addr().String(), target: target, imp: imp, l: l,
+		rand: rand.New(rand.NewSource(1)), conns: make(map[net.Conn]bool)}
+	p.wg.Add(1)
+	go p.accept()
+	return p, nil
+}
+
+// Close stops accepting connections, drops the ones in progress,
+// and waits for everything to finish
+func (p *Proxy) Close() error {
+	err := p.l.Close()
+	p.mu.Lock()
+	p.closing = true
+	for c := range p.conns {
+		c.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	return err
+}
+
+func (p *Proxy) accept() {
+	defer p.wg.Done()
+	for {
+		client, err := p.l.Accept()
+		if err != nil {
+			return
+		}
+		server, err := net.Dial("tcp", p.target)
+		if err != nil {
+			client.Close()
+			continue
+		}
+		if !p.open(client, server) {
+			// Close got in between Accept and Dial
+			client.Close()
+			server.Close()
+			return
+		}
+
+		// Each direction finishes separately, so that half-closed
+		// connections (client done sending, still reading) work
+		var dirs sync.WaitGroup
+		dirs.Add(2)
+		p.wg.Add(1)
+		go p.pipe(server, client, &dirs)
+		go p.pipe(client, server, &dirs)
+		go func() {
+			dirs.Wait()
+			client.Close()
+			server.Close()
+			p.forget(client)
+			p.forget(server)
+			p.wg.Done()
+		}()
+	}
+}
+
+// open notes new connections, for Close to drop; once Close has
+// started, it returns false instead
+func (p *Proxy) open(conns ...net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closing {
+		return false
+	}
+	for _, c := range conns {
+		p.conns[c] = true
+	}
+	return true
+}
+
+// forget forgets a connection that has finished
+func (p *Proxy) forget(c net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns, c)
+}
+
+// proxyChunk is data to be written once it is due
+type proxyChunk struct {
+	data []byte
+	due  time.Time
+}
+
+// pipe copies from src to dst, holding each chunk back until the
+// impaired network would have delivered it
+func (p *Proxy) pipe(dst net.Conn, src net.Conn, done *sync.WaitGroup) {
+	defer done.Done()
+
+	chunks := make(chan proxyChunk, proxyQueue)
+	go func() {
+		defer close(chunks)
+		var linkFree, lastDue time.Time
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := src.Read(buf)
+			now := time.Now()
+			for start := 0; start < n; {
+				end := n
+				if p.imp.ChunkSize > 0 && end-start > p.imp.ChunkSize {
+					end = start + p.imp.ChunkSize
+				}
+				data := append([]byte(nil), buf[start:end]...)
+				start = end
+
+				// With limited bandwidth, a chunk can't start until
+				// the one before it has finished going out
+				sent := now
+				if p.imp.Bandwidth > 0 {
+					if linkFree.After(sent) {
+						sent = linkFree
+					}
+					sent = sent.Add(time.Duration(int64(len(data)) * int64(time.Second) / p.imp.Bandwidth))
+					linkFree = sent
+				}
+				due := sent.Add(p.delay())
+				if due.Before(lastDue) {
+					due = lastDue
+				}
+				lastDue = due
+				chunks <- proxyChunk{data: data, due: due}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	failed := false
+	for c := range chunks {
+		if failed {
+			continue
+		}
+		time.Sleep(time.Until(c.due))
+		if _, err := dst.Write(c.data); err != nil {
+			failed = true
+			src.Close()
+		}
+	}
+
+	// Pass on the end of the data without closing the other direction
+	if tcp, ok := dst.(*net.TCPConn); ok && !failed {
+		tcp.CloseWrite()
+	}
+}
+
+// delay is the one-way delay for a chunk
+func (p *Proxy) delay() time.Duration {
+	d := p.imp.Latency / 2
+	if p.imp.Jitter > 0 {
+		p.mu.Lock()
+		d += time.Duration(p.rand.Int63n(int64(2*p.imp.Jitter)+1)) - p.imp.Jitter
+		p.mu.Unlock()
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
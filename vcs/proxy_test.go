@@ -0,0 +1,174 @@
+// vcs-torture/vcs/proxy_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// startEcho runs a server on localhost that sends back whatever it is
+// sent, until the client stops sending
+func startEcho(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+// echo sends data through the proxy and reads it back, returning how
+// long that took
+func echo(t *testing.T, p *Proxy, data []byte) time.Duration {
+	c, err := net.Dial("tcp", p.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	start := time.Now()
+	go func() {
+		c.Write(data)
+		c.(*net.TCPConn).CloseWrite()
+	}()
+	got, err := ioutil.ReadAll(c)
+	elapsed := time.Since(start)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("sent %d bytes, got %d back (%v)", len(data), len(got), err)
+	}
+	return elapsed
+}
+
+// TestProxyDelay makes sure that each direction gets half the latency,
+// give or take the jitter, and never less than nothing
+func TestProxyDelay(t *testing.T) {
+	tests := []struct {
+		imp      Impairment
+		min, max time.Duration
+	}{
+		{Impairment{}, 0, 0},
+		{Impairment{Latency: 100 * time.Millisecond}, 50 * time.Millisecond, 50 * time.Millisecond},
+		{Impairment{Latency: 100 * time.Millisecond, Jitter: 20 * time.Millisecond}, 30 * time.Millisecond, 70 * time.Millisecond},
+		{Impairment{Latency: 10 * time.Millisecond, Jitter: 50 * time.Millisecond}, 0, 55 * time.Millisecond},
+	}
+	for _, test := range tests {
+		p, err := StartProxy("127.0.0.1:1", test.imp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lo, hi := time.Hour, time.Duration(-1)
+		for i := 0; i < 2000; i++ {
+			d := p.delay()
+			if d < lo {
+				lo = d
+			}
+			if d > hi {
+				hi = d
+			}
+		}
+		p.Close()
+
+		// Jitter should reach (nearly) both ends
+		slack := test.imp.Jitter / 10
+		if lo < test.min || hi > test.max || lo > test.min+slack || hi < test.max-slack {
+			t.Errorf("%+v: delays from %s to %s, expected %s to %s", test.imp, lo, hi, test.min, test.max)
+		}
+	}
+}
+
+// TestProxyLatency makes sure that a round trip takes the latency
+func TestProxyLatency(t *testing.T) {
+	l := startEcho(t)
+	defer l.Close()
+
+	p, err := StartProxy(l.Addr().String(), Impairment{Latency: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	elapsed := echo(t, p, []byte("ping"))
+	if elapsed < 195*time.Millisecond || elapsed > time.Second {
+		t.Errorf("round trip took %s, expected about 200ms", elapsed)
+	}
+}
+
+// TestProxyBandwidth makes sure that data goes no faster than the
+// bandwidth, arrives in order when chunked, and that the two
+// directions don't share the bandwidth
+func TestProxyBandwidth(t *testing.T) {
+	l := startEcho(t)
+	defer l.Close()
+
+	const size = 100 * 1024
+	p, err := StartProxy(l.Addr().String(), Impairment{Bandwidth: 2 * size, ChunkSize: 1000,
+		Latency: 10 * time.Millisecond, Jitter: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	elapsed := echo(t, p, data)
+	if elapsed < 450*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("%d bytes each way at %d bytes/s took %s, expected about 0.5s", size, 2*size, elapsed)
+	}
+}
+
+// TestProxyClose makes sure that closing the proxy drops connections
+// in progress, rather than waiting for them
+func TestProxyClose(t *testing.T) {
+	l := startEcho(t)
+	defer l.Close()
+
+	p, err := StartProxy(l.Addr().String(), Impairment{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := net.Dial("tcp", p.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatalf("no echo: %s", err)
+	}
+
+	closed := make(chan error)
+	go func() { closed <- p.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited for an open connection")
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := c.Read(buf); err == nil {
+		t.Errorf("read %d bytes after Close", n)
+	}
+	if c, err := net.Dial("tcp", p.Addr); err == nil {
+		c.Close()
+		t.Errorf("proxy still accepting after Close")
+	}
+}
@@ -3,10 +3,13 @@
 package vcs
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"vcs-torture/gsos"
@@ -16,8 +19,119 @@ type RepoOptions struct {
 	NumCommits    int
 	AddsPerCommit int
 	FilesPerAdd   int
+
+	// What to do when a version control command fails
+	OnError ErrorPolicy
+	Retries int
+
+	// Calibrate is how many times to time a trivial command to
+	// measure startup overhead before a commit run; 0 means don't
+	Calibrate int
+
+	// SampleEvery is how often (in commits) to measure the size of
+	// the repo's store; 0 means never
+	SampleEvery int
+
+	// Resume continues a previous commit run (see Checkpoint) instead
+	// of starting at commit 1; NumCommits is still the total to reach
+	Resume bool
+
+	// ModifyPercent of the files already committed are edited in each
+	// commit, in the EditStyles (taken in turn); EditLines is how many
+	// lines an append or insert adds, and RewriteFraction how much of
+	// a file a rewrite overwrites
+	ModifyPercent   float64
+	EditStyles      []string
+	EditLines       int
+	RewriteFraction float64
+
+	// Each commit also deletes DeleteRate committed files, renames
+	// RenameRate files and moves DirMoveRate whole directories (these
+	// can be fractions, e.g. 0.1 is one every tenth commit)
+	DeleteRate  float64
+	RenameRate  float64
+	DirMoveRate float64
+
+	// Seed picks which files are edited, deleted and moved, and the new
+	// content of edited files (see WorktreeOptions.Seed); a commit run
+	// with the same seed makes the same repo anywhere
+	Seed int64
+
+	// A branch run makes NumBranches branches, one every BranchEvery
+	// commits (0 spreads them evenly over the history). NamedBranches
+	// makes Mercurial named branches instead of bookmarks.
+	NumBranches   int
+	BranchEvery   int
+	NamedBranches bool
+
+	// A checkout run switches between the branch tips, then walks back
+	// through history NumCheckouts times, CheckoutDistance commits at
+	// a time, and forward again to the tip
+	NumCheckouts     int
+	CheckoutDistance int
+
+	// CloneMode is the kind of clone a clone run makes (see the
+	// backend's CloneModes; "" is the first of them), and CloneDepth
+	// is how many commits a git shallow clone gets
+	CloneMode  string
+	CloneDepth int
+
+	// A network run serves the repo over Protocol (see the backend's
+	// ServeProtocols; "" is the first of them), and pushes PushFiles
+	// new files of PushFileSize bytes through it
+	Protocol     string
+	PushFiles    int
+	PushFileSize int64
+
+	// Impairment puts a network run's server behind a Proxy that
+	// simulates a slow network; Proxy uses one even for a perfect
+	// network (to measure the cost of the proxy itself)
+	Impairment Impairment
+	Proxy      bool
+}
+
+// ErrorPolicy says what a commit run does when a command fails. The
+// failure is always recorded in the results first.
+type ErrorPolicy int
+
+const (
+	// ErrorAbort stops the run cleanly at the first failure
+	ErrorAbort ErrorPolicy = iota
+
+	// ErrorSkip carries on with the next add batch or commit
+	ErrorSkip
+
+	// ErrorRetry runs the failed command again (up to Retries
+	// times) and then aborts if it still fails
+	ErrorRetry
+)
+
+var errorPolicyNames = []string{"abort", "skip", "retry"}
+
+// ParseErrorPolicy converts abort|skip|retry to an ErrorPolicy
+func ParseErrorPolicy(name string) (ErrorPolicy, bool) {
+	for i, v := range errorPolicyNames {
+		if v == name {
+			return ErrorPolicy(i), true
+		}
+	}
+	return ErrorAbort, false
 }
 
+func (p ErrorPolicy) String() string {
+	return errorPolicyNames[p]
+}
+
+// ErrStopped is returned when a callback asked for a run to stop
+var ErrStopped = errors.New("stopped")
+
+// ErrAborted is returned when a run was interrupted (e.g. by ctrl-c)
+var ErrAborted = errors.New("interrupted")
+
+// ErrWorktreeExhausted is returned when a commit run has added every
+// worktree file and needs more
+var ErrWorktreeExhausted = errors.New("worktree exhausted")
+
 type Repo struct {
 	RepoOptions
 
@@ -28,24 +142,73 @@ type Repo struct {
 	dest     string
 	repoName string
 	vcs      string
-	overhead float64
+	backend  Backend
 
-	repo   string
-	server string // for client/server systems like Subversion
+	// overhead is subtracted from timings to give corrected times
+	// (it's calibration.Median, once calibrated)
+	overhead    float64
+	calibration Overhead
+
+	repo      string
+	serverDir string // for client/server systems like Subversion
 
 	numCommits   int
 	numHeadFiles int
 
+	// signals tells us when to stop a run early
+	signals *gsos.CatchSignals
+
+	// Where a commit run is, for results records
+	results    *Results
+	commit     int
+	opFiles    int
+	indexFiles int
+	indexBytes int64
+	modified   int
+	deleted    int
+	renamed    int
+	movedDirs  int
+	fromCommit int
+	branch     string
+	protocol   string
+	impairment *Impairment
+
+	// moved maps worktree files that have been renamed, moved or
+	// deleted to where they are now ("" if deleted)
+	moved map[string]string
+
+	// dirs indexes files by directory for directory moves
+	dirs *dirIndex
+
 	Worktree *Worktree
 }
 
 func NewRepo(dest string, repoName string, vcs string, startTime time.Time, options RepoOptions) *Repo {
 	r := &Repo{dest: dest, repoName: repoName, vcs: vcs, RepoOptions: options}
 	r.repo = filepath.Join(dest, repoName)
-	if r.vcs == "svn" {
-		r.server = fmt.Sprintf("file:///%s-svnrepo", r.repo)
+	r.backend, _ = LookupBackend(vcs)
+	if r.backend != nil {
+		r.serverDir = r.backend.ServerDir(r)
 	}
 	r.startTime = startTime
+	if r.Retries == 0 {
+		r.Retries = 3
+	}
+	if len(r.EditStyles) == 0 {
+		r.EditStyles = []string{EditAppend}
+	}
+	if r.EditLines == 0 {
+		r.EditLines = 5
+	}
+	if r.RewriteFraction == 0 {
+		r.RewriteFraction = 0.1
+	}
+	if r.PushFiles == 0 {
+		r.PushFiles = 100
+	}
+	if r.PushFileSize == 0 {
+		r.PushFileSize = 10000
+	}
 
 	// Set up command line limit (these are puposely much lower than
 	// the real limits)
@@ -62,33 +225,140 @@ func (r *Repo) GetRepo() string {
 	return r.repo
 }
 
+// AddWorktree sets up the worktree files to commit. When resuming, files
+// that earlier runs deleted or moved aren't made again.
 func (r *Repo) AddWorktree(options WorktreeOptions) {
 	r.Worktree = NewWorktree(r.dest, r.repoName, options)
+	r.Worktree.SetSignals(r.signals)
+	if r.Resume {
+		if cp, err := r.readCheckpoint(); err == nil && cp.Vcs == r.vcs {
+			r.moved = cp.Moved
+		}
+	}
+	r.Worktree.Moved = r.moved
+}
+
+// VerifyWorktree checks the worktree against its manifest (see
+// Worktree.Verify), allowing for files that commit runs have moved or
+// deleted.
+func (r *Repo) VerifyWorktree(callback func(cb *WorktreeCallbackData) bool) (*VerifyStats, error) {
+	if cp, err := r.readCheckpoint(); err == nil {
+		r.Worktree.Moved = cp.Moved
+	}
+	return r.Worktree.Verify(callback)
+}
+
+// RemoveOptions controls DeleteRepo
+type RemoveOptions struct {
+	// Force removes things even if the manifest doesn't list them
+	Force bool
+
+	// DryRun only reports what would be removed
+	DryRun bool
 }
 
 // DeleteRepo removes the repo (and associated data, e.g the actual repo
-// for Subversion operations).
-// TBD this is incredibly dangerous. We should probably write a
-// log file and check it so someone doesn't accidentally delete their
-// hard disk - we should only delete things we created. Suggest creating
-// a .vcs-torture file in the --dest dir and then refusing to do anything
-// if it doesn't exist, and only delete things mentioned in this file.
-func DeleteRepo(dest string, repoName string, vcs string) bool {
-	err := os.RemoveAll(filepath.Join(dest, repoName))
-	if err == nil && vcs == "svn" {
-		err = os.RemoveAll(filepath.Join(dest, repoName+"-svnrepo"))
-	}
-	return err == nil
+// for Subversion operations, the checkpoint and any clones). If vcs is empty, only
+// the repo directory itself is removed. This is dangerous, so unless
+// forced, it refuses to touch anything not listed in the manifest
+// (ManifestName) in dest - we only delete things we created. It returns
+// the paths that were (or, for a dry run, would be) removed.
+func DeleteRepo(dest string, repoName string, vcs string, options RemoveOptions) ([]string, error) {
+	r := NewRepo(dest, repoName, vcs, time.Now(), RepoOptions{})
+	if r.backend == nil && vcs != "" {
+		return nil, fmt.Errorf("Unknown version control system: %s", vcs)
+	}
+
+	m, exists, err := LoadManifest(dest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Work out what there is to remove, and make sure it's ours
+	var paths []string
+	for _, path := range []string{r.repo, r.serverDir, r.checkpointPath(), worktreeManifestPath(r.repo)} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Lstat(path); err != nil {
+			continue
+		}
+		if !options.Force && !m.Owns(path) {
+			if !exists {
+				return nil, fmt.Errorf("refusing to remove %s: no %s in %s (use --force)", path, ManifestName, dest)
+			}
+			return nil, fmt.Errorf("refusing to remove %s: not created by vcs-torture (use --force)", path)
+		}
+		paths = append(paths, path)
+	}
+
+	// Clones are only ever removed if we made them
+	clones, _ := filepath.Glob(r.repo + "-clone-*")
+	for _, path := range clones {
+		if m.Owns(path) {
+			paths = append(paths, path)
+		}
+	}
+
+	if options.DryRun || len(paths) == 0 {
+		return paths, nil
+	}
+
+	if r.backend == nil {
+		err = removeRepoDirs(r)
+	} else {
+		err = r.backend.Remove(r)
+	}
+
+	// A checkpoint, a worktree manifest or a clone is meaningless once
+	// its repo is gone
+	for _, path := range clones {
+		if err == nil && m.Owns(path) {
+			err = os.RemoveAll(path)
+		}
+	}
+	for _, path := range []string{r.checkpointPath(), worktreeManifestPath(r.repo)} {
+		if err == nil {
+			err = os.Remove(path)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		for _, path := range paths {
+			m.Drop(path)
+		}
+		err = m.Save()
+	}
+	return paths, err
 }
 
 func (r *Repo) SetVerbose(verbose bool) {
-	r.verbose = true
+	r.verbose = verbose
+}
+
+// SetSignals lets a commit run be interrupted cleanly
+func (r *Repo) SetSignals(signals *gsos.CatchSignals) {
+	r.signals = signals
+	if r.Worktree != nil {
+		r.Worktree.SetSignals(signals)
+	}
+}
+
+// SetResults sends a record of every timed operation to results
+func (r *Repo) SetResults(results *Results) {
+	r.results = results
 }
 
 // ----------------------------------------------------------------------------------------------
 
 // Create makes a new repo or gets information about an existing repo.
-func (r *Repo) Create() bool {
+func (r *Repo) Create() error {
 
 	// If it already exists, query information about it
 	if _, err := os.Stat(r.repo); err == nil {
@@ -99,94 +369,125 @@ func (r *Repo) Create() bool {
 }
 
 // loadInfo fetches useful information about an existing repo
-func (r *Repo) loadInfo() bool {
-
-	// Git
-	if r.vcs == "git" {
-		// Get the number of files in the tip of the tree
-		_, stdout, _ := RunGitCommand(r.repo, nil, "ls-tree", "-r", "HEAD")
-		r.numHeadFiles = len(gsos.DataToLines(stdout))
-
-		// Get the number of commits in the repo
-		_, stdout, _ = RunGitCommand(r.repo, nil, "log", "--oneline")
-		r.numCommits = len(gsos.DataToLines(stdout))
-
-		return true
+func (r *Repo) loadInfo() error {
+	if r.backend == nil {
+		return fmt.Errorf("Unknown version control system: %s", r.vcs)
 	}
 
-	// Mercurial
-	if r.vcs == "hg" {
-		// Not supported yet
-		return false
+	// Get the number of files in the tip of the tree
+	numHeadFiles, err := r.backend.HeadFiles(r)
+	if err != nil {
+		return err
 	}
 
-	// Subversion
-	if r.vcs == "svn" {
-		// Not supported yet
-		return false
+	// Get the number of commits in the repo
+	numCommits, err := r.backend.NumCommits(r)
+	if err != nil {
+		return err
 	}
 
-	// Unknown source control system
-	return false
+	r.numHeadFiles = numHeadFiles
+	r.numCommits = numCommits
+	return nil
 }
 
 // createRepo creates a new empty repo
-func (r *Repo) createRepo() bool {
-	// Create repo directory
+func (r *Repo) createRepo() error {
+	if r.backend == nil {
+		return fmt.Errorf("Unknown version control system: %s", r.vcs)
+	}
+
+	// Create repo directory, and note that it's ours
 	err := os.Mkdir(r.repo, os.ModePerm)
 	if err != nil {
-		return false
+		return err
 	}
-
-	// Git
-	if r.vcs == "git" {
-		// "git init"
-		delta, stdout, stderr := RunGitCommand(r.repo, nil, "init")
-		if r.verbose {
-			fmt.Printf("T+%.2f: (elapsed=%.4f) git init\n", time.Since(r.startTime).Seconds(), delta)
-			showStdoutStderr(stdout, stderr)
+	if err := recordOwned(r.dest, OwnedRepo, r.vcs, r.repo); err != nil {
+		return err
+	}
+	if r.serverDir != "" {
+		if err := recordOwned(r.dest, OwnedServer, r.vcs, r.serverDir); err != nil {
+			return err
 		}
+	}
 
-		// "git config gc.auto 0" (turn off auto GC for this repo)
-		delta, stdout, stderr = RunGitCommand(r.repo, nil, "config", "gc.auto", "0")
-		if r.verbose {
-			fmt.Printf("T+%.2f: (elapsed=%.4f) git config gc.auto 0\n", time.Since(r.startTime).Seconds(), delta)
-			showStdoutStderr(stdout, stderr)
-		}
+	return r.backend.Init(r)
+}
 
-		// "git config gc.autodetach false" for good measure
-		delta, stdout, stderr = RunGitCommand(r.repo, nil, "config", "gc.autodetach", "false")
+// run runs a version control command on behalf of a backend, showing
+// the command and its output in verbose mode, and recording it
+// as part of operation op in the results. Failed commands are
+// retried if that is the error policy; every attempt is recorded.
+func (r *Repo) run(op string, exe string, dir string, params ...string) (*CmdResult, error) {
+	var res *CmdResult
+	var err error
+	for attempt := 0; ; attempt++ {
+		res, err = RunExternal(exe, dir, nil, params...)
 		if r.verbose {
-			fmt.Printf("T+%.2f: (elapsed=%.4f) git config gc.autodetach false\n", time.Since(r.startTime).Seconds(), delta)
-			showStdoutStderr(stdout, stderr)
+			fmt.Printf("T+%.2f: (elapsed=%.4f user=%.4f sys=%.4f rss=%.1fMB) %s %s\n", time.Since(r.startTime).Seconds(),
+				res.Elapsed, res.Usage.UserTime, res.Usage.SystemTime, float64(res.Usage.MaxRSS)/(1024*1024),
+				exe, strings.Join(params, " "))
+			showStdoutStderr(res.Stdout, res.Stderr)
+			if err != nil {
+				fmt.Printf("(error): %s\n", err)
+			}
 		}
-	}
 
-	if r.vcs == "hg" {
-		// "hg init"
-		delta, stdout, stderr := RunHgCommand(r.repo, nil, "init")
-		if r.verbose {
-			fmt.Printf("T+%.2f: (elapsed=%.4f) hg init\n", time.Since(r.startTime).Seconds(), delta)
-			showStdoutStderr(stdout, stderr)
+		rec := newCmdRecord(op, res, attempt)
+		if r.overhead > 0 {
+			rec.Overhead = r.overhead
+			rec.Corrected = r.corrected(res.Elapsed)
 		}
-	}
+		r.record(rec)
 
-	if r.vcs == "svn" {
-		// "svnadmin create"
-		delta, stdout, stderr := RunSvnadminCommand(r.dest, nil, "create", fmt.Sprintf("%s-svnrepo", r.repoName))
-		if r.verbose {
-			fmt.Printf("T+%.2f: (elapsed=%.4f) svnadmin create\n", time.Since(r.startTime).Seconds(), delta)
-			showStdoutStderr(stdout, stderr)
-		}
-		// "svn checkout"
-		delta, stdout, stderr = RunSvnCommand(r.dest, nil, "checkout", r.server, r.repo)
-		if r.verbose {
-			fmt.Printf("T+%.2f: (elapsed=%.4f) svn checkout\n", time.Since(r.startTime).Seconds(), delta)
-			showStdoutStderr(stdout, stderr)
+		// Running out of time or memory will just happen again
+		if err == nil || r.OnError != ErrorRetry || attempt >= r.Retries || errors.Is(err, ErrLimitExceeded) {
+			return res, err
 		}
 	}
+}
 
-	return true
+// record fills in the run position and writes a result
+func (r *Repo) record(res *Result) {
+	if r.results == nil {
+		return
+	}
+	res.Vcs = r.vcs
+	res.Commit = r.commit
+	res.Files = r.opFiles
+	res.IndexFiles = r.indexFiles
+	res.IndexBytes = r.indexBytes
+	res.Seed = r.Seed
+	if r.Worktree != nil && len(r.Worktree.Content) > 0 {
+		res.Content = r.Worktree.Content.String()
+	}
+	if r.Worktree != nil && r.Worktree.Sizes != nil {
+		res.Sizes = r.Worktree.Sizes.String()
+	}
+	if r.Worktree != nil && r.Worktree.Layout != LayoutBalanced {
+		res.Layout = r.Worktree.Layout
+	}
+	if r.Worktree != nil && r.Worktree.Profile != nil {
+		res.Profile = r.Worktree.Profile.String()
+	}
+	res.Modified = r.modified
+	res.Deleted = r.deleted
+	res.Renamed = r.renamed
+	res.MovedDirs = r.movedDirs
+	res.FromCommit = r.fromCommit
+	res.Branch = r.branch
+	res.Protocol = r.protocol
+	if r.impairment != nil {
+		res.Latency = r.impairment.Latency.Seconds()
+		res.Jitter = r.impairment.Jitter.Seconds()
+		res.Bandwidth = r.impairment.Bandwidth
+		res.ChunkSize = r.impairment.ChunkSize
+		res.Proxied = true
+	}
+	res.Time = time.Since(r.startTime).Seconds()
+	if err := r.results.Record(res); err != nil {
+		log.Fatalf("Couldn't write results: %s\n", err)
+	}
 }
 
 func showStdoutStderr(stdout []byte, stderr []byte) {
@@ -212,18 +513,90 @@ type CommitCallbackData struct {
 	PackObjects   int
 }
 
-func (r *Repo) Commit(callback func(cb *CommitCallbackData) bool) bool {
+// Commit runs the commit loop, adding files from the worktree and
+// committing them. A failed command is handled according to r.OnError;
+// if the run stops because of it, the error says where. If the run is
+// interrupted, the in-flight add or commit is allowed to finish, files
+// already added are committed, and ErrAborted is returned. If the
+// worktree runs out of files, what has been added is committed and
+// ErrWorktreeExhausted is returned. Either way, the results get a
+// summary and a checkpoint is written (as it is after every commit, so
+// that a run that dies can be resumed).
+func (r *Repo) Commit(callback func(cb *CommitCallbackData) bool) error {
 	//fmt.Printf("(*Repo).Commit\n")
 	var cb CommitCallbackData
 
-	var sumAddTime float64
-	var sumCommitTime float64
+	var sumAddTime, sumAddCorrected float64
+	var sumModifyTime, sumModifyCorrected float64
+	var sumMoveTime, sumMoveCorrected float64
+	var sumCommitTime, sumCommitCorrected float64
+	var runErr error
 	pos := 0
-	for cb.Commit = 1; cb.Commit <= r.NumCommits; cb.Commit++ {
+	committed := 0
+	sampled := -1
+	if err := r.calibrate(r.Calibrate); err != nil {
+		return err
+	}
+
+	if r.Resume {
+		cp, err := r.resumePoint()
+		if err != nil {
+			return err
+		}
+		pos, committed, r.indexFiles = cp.Files, cp.Commits, cp.IndexFiles
+		for i := 0; i < pos; i++ {
+			if _, ok := r.currentPath(i); ok {
+				r.indexBytes += r.Worktree.bytesOf(i, i+1)
+			}
+		}
+		cb.NumIndexFiles = r.indexFiles
+		if r.verbose {
+			fmt.Printf("Resuming after commit %d at worktree file %d\n", committed, pos)
+		}
+	}
+
+	for cb.Commit = committed + 1; cb.Commit <= r.NumCommits; cb.Commit++ {
+		r.commit = cb.Commit
+		if r.aborted() {
+			runErr = ErrAborted
+			break
+		}
+		if pos >= len(r.Worktree.Files) {
+			runErr = fmt.Errorf("commit %d: %w after %d files (use a bigger --worktree-file-count)",
+				cb.Commit, ErrWorktreeExhausted, pos)
+			break
+		}
+
+		// Change some of the files already committed
+		if r.ModifyPercent > 0 {
+			deltaModify, correctedModify, err := r.modifyCommitted(cb.Commit, pos)
+			sumModifyTime += deltaModify
+			sumModifyCorrected += correctedModify
+			if err != nil && r.OnError != ErrorSkip {
+				runErr = fmt.Errorf("commit %d: %s", cb.Commit, err)
+				break
+			}
+		}
+
+		// Delete, rename and move some of them
+		if r.DeleteRate > 0 || r.RenameRate > 0 || r.DirMoveRate > 0 {
+			deltaMove, correctedMove, err := r.reshape(cb.Commit, pos)
+			sumMoveTime += deltaMove
+			sumMoveCorrected += correctedMove
+			if err == nil && r.renamed+r.movedDirs > 0 {
+				// This is where rename detection happens
+				_, err = r.backend.Status(r)
+			}
+			if err != nil && r.OnError != ErrorSkip {
+				runErr = fmt.Errorf("commit %d: %s", cb.Commit, err)
+				break
+			}
+		}
 
 		// Add files for our commit
 		numToAdd := r.AddsPerCommit * r.FilesPerAdd
 		add := 0
+		stop := false
 		for add < numToAdd {
 			amt := r.FilesPerAdd
 			if add+amt > numToAdd {
@@ -231,29 +604,114 @@ func (r *Repo) Commit(callback func(cb *CommitCallbackData) bool) bool {
 			}
 			addList := r.getFileSubset(pos+add, amt)
 			amt = len(addList)
-			deltaAdd := r.addFiles(addList) - r.overhead
+			if amt == 0 {
+				// Out of files: commit what we have, and stop next time
+				break
+			}
+			deltaAdd, correctedAdd, err := r.addFiles(addList)
 			sumAddTime += deltaAdd
+			sumAddCorrected += correctedAdd
+			if err == nil {
+				r.indexBytes += r.Worktree.bytesOf(pos+add, pos+add+amt)
+			}
 
 			add += amt
-			cb.NumIndexFiles += amt
+			cb.NumIndexFiles = r.indexFiles
+			if err != nil && r.OnError != ErrorSkip {
+				runErr = fmt.Errorf("commit %d: %s", cb.Commit, err)
+				break
+			}
+			if r.aborted() {
+				stop = true
+				break
+			}
 			if callback != nil && callback(&cb) {
 				break
 			}
 		}
 
 		pos += add
+		if runErr != nil {
+			break
+		}
 
-		// Now make the commit
-		deltaCommit := r.makeCommit(cb.Commit) - r.overhead
+		// Now make the commit (even if interrupted, so that the repo
+		// matches the checkpoint)
+		r.opFiles = add
+		deltaCommit, err := r.makeCommit(cb.Commit)
 		sumCommitTime += deltaCommit
+		sumCommitCorrected += r.corrected(deltaCommit)
+		if err != nil && r.OnError != ErrorSkip {
+			runErr = fmt.Errorf("commit %d: %s", cb.Commit, err)
+			break
+		}
+
+		// A skipped commit leaves its files pending for the next one,
+		// and the checkpoint as it was, so that it still matches the repo
+		if err == nil {
+			committed++
+			r.modified, r.deleted, r.renamed, r.movedDirs = 0, 0, 0, 0
+			if err := r.writeCheckpoint(r.checkpoint(committed, pos)); err != nil {
+				runErr = err
+				break
+			}
+		}
 
+		// Every so often, see how big the repo has got
+		if r.SampleEvery > 0 && committed%r.SampleEvery == 0 && sampled != committed {
+			if err := r.sampleStore(&cb); err != nil && r.OnError != ErrorSkip {
+				runErr = fmt.Errorf("commit %d: measuring repo: %s", cb.Commit, err)
+				break
+			}
+			sampled = committed
+		}
+
+		if stop {
+			runErr = ErrAborted
+			break
+		}
 		if callback != nil && callback(&cb) {
 			break
 		}
 	}
 
+	// Always finish with the final size of the repo
+	if r.SampleEvery > 0 && sampled != committed && runErr == nil {
+		if err := r.sampleStore(&cb); err != nil && r.OnError != ErrorSkip {
+			runErr = fmt.Errorf("measuring repo: %s", err)
+		}
+	}
+
+	r.opFiles = pos
+	r.modified, r.deleted, r.renamed, r.movedDirs = 0, 0, 0, 0
+	r.record(&Result{Op: "summary", Elapsed: sumAddTime + sumModifyTime + sumMoveTime + sumCommitTime,
+		AddTime: sumAddTime, ModifyTime: sumModifyTime, MoveTime: sumMoveTime, CommitTime: sumCommitTime,
+		Overhead: r.overhead, Corrected: sumAddCorrected + sumModifyCorrected + sumMoveCorrected + sumCommitCorrected,
+		AddTimeCorrected: sumAddCorrected, ModifyTimeCorrected: sumModifyCorrected,
+		MoveTimeCorrected: sumMoveCorrected, CommitTimeCorrected: sumCommitCorrected})
+
+	if err := r.writeCheckpoint(r.checkpoint(committed, pos)); err != nil && runErr == nil {
+		runErr = err
+	}
+	if r.Worktree.manifestChanged {
+		if err := r.Worktree.saveManifest(); err != nil && runErr == nil {
+			runErr = err
+		}
+	}
+
 	cb.Done = true
-	return callback == nil || !callback(&cb)
+	if callback != nil && callback(&cb) && runErr == nil {
+		runErr = ErrStopped
+	}
+	if runErr == ErrAborted {
+		runErr = fmt.Errorf("%w after %d of %d commits (%d files)", ErrAborted, committed, r.NumCommits, pos)
+	}
+	return runErr
+}
+
+// aborted returns true if the user has asked us to stop
+func (r *Repo) aborted() bool {
+	return r.signals.Aborted()
 }
 
 // Get some files
@@ -262,24 +720,60 @@ func (r *Repo) getFileSubset(pos, amt int) []string {
 	if pos+amt > len(r.Worktree.Files) {
 		amt = len(r.Worktree.Files) - pos
 	}
+	if amt < 0 {
+		amt = 0
+	}
 	addList := make([]string, amt)
 	copy(addList, r.Worktree.Files[pos:pos+amt])
 	return addList
 }
 
 // Do "git add" on this set of files. We may need to break this
-// up into more than one command-line invocation
-func (r *Repo) addFiles(addList []string) float64 {
+// up into more than one command-line invocation. With the skip
+// policy, failed invocations are passed over and the first error
+// is returned once everything else has been added. This returns
+// both raw and overhead-corrected elapsed time (overhead is paid
+// once per invocation).
+func (r *Repo) addFiles(addList []string) (float64, float64, error) {
 	//fmt.Printf("(*Repo).addFiles\n")
+	return r.inBatches(addList, func(filelist []string) (*CmdResult, error) {
+		r.opFiles = len(filelist)
+		r.indexFiles += len(filelist)
+		res, err := r.backend.Add(r, filelist)
+		if err != nil {
+			r.indexFiles -= len(filelist)
+		}
+		return res, err
+	})
+}
 
-	var addElapsed float64
-	for start := 0; start < len(addList); {
+// modifyCommitted edits some of the first numCommitted worktree files
+// (see pickModified) and tells the backend about them, returning raw
+// and corrected times for the latter like addFiles
+func (r *Repo) modifyCommitted(commit int, numCommitted int) (float64, float64, error) {
+	paths, err := r.modifyFiles(commit, r.pickModified(commit, numCommitted))
+	if err != nil || len(paths) == 0 {
+		return 0, 0, err
+	}
+	r.modified = len(paths)
+	return r.inBatches(paths, func(filelist []string) (*CmdResult, error) {
+		r.opFiles = len(filelist)
+		return r.backend.AddModified(r, filelist)
+	})
+}
+
+// inBatches runs fn on as many files at a time as will fit on a
+// command line, following the error policy (see addFiles)
+func (r *Repo) inBatches(list []string, fn func(filelist []string) (*CmdResult, error)) (float64, float64, error) {
+	var elapsed, corrected float64
+	var firstErr error
+	for start := 0; start < len(list); {
 
 		filelist := make([]string, 0, 100)
 		cmdsize := 0
 
-		for i := start; i < len(addList); i++ {
-			path := addList[i]
+		for i := start; i < len(list); i++ {
+			path := list[i]
 			if cmdsize+1+len(path) > r.maxCmdline {
 				break
 			}
@@ -287,33 +781,29 @@ func (r *Repo) addFiles(addList []string) float64 {
 			cmdsize += 1 + len(path)
 		}
 
-		// Add this subset
-		var delta float64
-		if r.vcs == "git" {
-			delta, _, _ = RunGitCommand(r.repo, nil, append([]string{"add"}, filelist...)...)
-		} else if r.vcs == "hg" {
-			delta, _, _ = RunHgCommand(r.repo, nil, append([]string{"add"}, filelist...)...)
-		} else if r.vcs == "svn" {
-			delta, _, _ = RunSvnCommand(r.repo, nil, append([]string{"add", "--parents" }, filelist...)...)
-		}
-		addElapsed += delta
+		// Do this subset
+		res, err := fn(filelist)
+		elapsed += res.Elapsed
+		corrected += r.corrected(res.Elapsed)
 
 		start += len(filelist)
+
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			if r.OnError != ErrorSkip {
+				break
+			}
+		}
 	}
 
-	return addElapsed
+	return elapsed, corrected, firstErr
 }
 
 // Do "git commit" on the current repo (which should have files added to it)
-func (r *Repo) makeCommit(n int) float64 {
+func (r *Repo) makeCommit(n int) (float64, error) {
 	//fmt.Printf("(*Repo).makeCommit\n")
-	var elapsed float64
-	if r.vcs == "git" {
-		elapsed, _, _ = RunGitCommand(r.repo, nil, "commit", "-m", fmt.Sprintf("commit %d", n))
-	} else if r.vcs == "hg" {
-		elapsed, _, _ = RunHgCommand(r.repo, nil, "commit", "-m", fmt.Sprintf("commit %d", n))
-	} else if r.vcs == "svn" {
-		elapsed, _, _ = RunSvnCommand(r.repo, nil, "commit", "-m", fmt.Sprintf("commit %d", n))
-	}
-	return elapsed
+	res, err := r.backend.Commit(r, fmt.Sprintf("commit %d", n))
+	return res.Elapsed, err
 }
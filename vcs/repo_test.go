@@ -0,0 +1,283 @@
+// vcs-torture/vcs/repo_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"vcs-torture/gsos"
+)
+
+// fakeBackend is a version control system that only keeps count. Its
+// commits succeed unless their attempt number is in fail, version is
+// its VersionCommand, and branches and checkouts are logged. Methods it
+// doesn't have panic (through the nil Backend).
+type fakeBackend struct {
+	Backend
+
+	fail     map[int]bool
+	attempts int
+	commits  int
+	files    int
+	pending  int
+	version  []string
+	branches []string
+	log      []string
+}
+
+func (*fakeBackend) Name() string {
+	return "fake"
+}
+
+func (*fakeBackend) Init(r *Repo) error {
+	return nil
+}
+
+func (b *fakeBackend) Add(r *Repo, files []string) (*CmdResult, error) {
+	b.pending += len(files)
+	return &CmdResult{Exe: "fake", Params: []string{"add"}, Outcome: OutcomeOK}, nil
+}
+
+func (b *fakeBackend) Commit(r *Repo, message string) (*CmdResult, error) {
+	b.attempts++
+	if b.fail[b.attempts] {
+		err := fmt.Errorf("fake commit failed")
+		return &CmdResult{Exe: "fake", Params: []string{"commit"}, Outcome: OutcomeError, ExitCode: 1, Err: err}, err
+	}
+	b.commits++
+	b.files += b.pending
+	b.pending = 0
+	return &CmdResult{Exe: "fake", Params: []string{"commit"}, Outcome: OutcomeOK}, nil
+}
+
+func (b *fakeBackend) HeadFiles(r *Repo) (int, error) {
+	return b.files, nil
+}
+
+func (b *fakeBackend) NumCommits(r *Repo) (int, error) {
+	return b.commits, nil
+}
+
+// newFakeRepo makes a repo in dest for a fakeBackend, with a worktree
+// of numFiles small files
+func newFakeRepo(t *testing.T, dest string, b *fakeBackend, numFiles int, options RepoOptions) *Repo {
+	r := NewRepo(dest, "repo", "fake", time.Now(), options)
+	r.backend = b
+	if err := r.Create(); err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	r.AddWorktree(WorktreeOptions{NumFiles: numFiles, FilesPerDir: 10, DirsPerDir: 4, FileSize: 100})
+	if !r.Worktree.Generate(nil) {
+		t.Fatal("Generate stopped early")
+	}
+	return r
+}
+
+// fakeCommand stands in for the command name with a shell script
+// (made in dir), until the function it returns is called
+func fakeCommand(t *testing.T, dir string, name string, script string) func() {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	old, ok := commandPaths[name]
+	commandPaths[name] = path
+	return func() {
+		if ok {
+			commandPaths[name] = old
+		} else {
+			delete(commandPaths, name)
+		}
+	}
+}
+
+func TestParseErrorPolicy(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ErrorPolicy
+		ok   bool
+	}{
+		{"abort", ErrorAbort, true},
+		{"skip", ErrorSkip, true},
+		{"retry", ErrorRetry, true},
+		{"", ErrorAbort, false},
+		{"Skip", ErrorAbort, false},
+		{"ignore", ErrorAbort, false},
+	}
+	for _, test := range tests {
+		got, ok := ParseErrorPolicy(test.in)
+		if got != test.want || ok != test.ok {
+			t.Errorf("ParseErrorPolicy(%q) = %s, %t, expected %s, %t", test.in, got, ok, test.want, test.ok)
+		}
+		if ok && got.String() != test.in {
+			t.Errorf("%q.String() = %q", test.in, got.String())
+		}
+	}
+}
+
+// TestRunOnError makes sure that a failing command is run once, or
+// retried, depending on the error policy, and that every attempt is
+// recorded with its exit code and stderr
+func TestRunOnError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	dir, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Fails the first time it's run (in a directory), then succeeds
+	const failOnce = `test -f ran && exit 0; touch ran; echo broken >&2; exit 3`
+
+	tests := []struct {
+		policy   ErrorPolicy
+		script   string
+		attempts int
+		ok       bool
+	}{
+		{ErrorAbort, "echo broken >&2; exit 3", 1, false},
+		{ErrorSkip, "echo broken >&2; exit 3", 1, false},
+		{ErrorRetry, "echo broken >&2; exit 3", 3, false},
+		{ErrorRetry, failOnce, 2, true},
+		{ErrorAbort, failOnce, 1, false},
+	}
+	for i, test := range tests {
+		wd := filepath.Join(dir, fmt.Sprint(i))
+		os.Mkdir(wd, 0755)
+		path := filepath.Join(dir, fmt.Sprintf("results%d.ndjson", i))
+		results, err := OpenResults(path, "run")
+		if err != nil {
+			t.Fatalf("OpenResults: %s", err)
+		}
+		r := NewRepo(dir, "repo", "git", time.Now(), RepoOptions{OnError: test.policy, Retries: 2})
+		r.SetResults(results)
+		_, err = r.run("commit", "sh", wd, "-c", test.script)
+		results.Close()
+
+		if (err == nil) != test.ok {
+			t.Errorf("%s %q: error %v", test.policy, test.script, err)
+		}
+		records, _ := readResults(t, path)
+		if len(records) != test.attempts {
+			t.Errorf("%s %q: %d attempts, expected %d", test.policy, test.script, len(records), test.attempts)
+			continue
+		}
+		for n, rec := range records {
+			if rec.Attempt != n || rec.Op != "commit" || rec.Command != "sh -c" {
+				t.Errorf("%s %q: attempt %d is %+v", test.policy, test.script, n, rec)
+			}
+			if n == len(records)-1 && test.ok {
+				if rec.Outcome != OutcomeOK || rec.ExitCode != 0 || rec.Error != "" || rec.Stderr != "" {
+					t.Errorf("%s %q: last attempt is %+v", test.policy, test.script, rec)
+				}
+			} else if rec.Outcome != OutcomeError || rec.ExitCode != 3 || rec.Error == "" || rec.Stderr != "broken\n" {
+				t.Errorf("%s %q: failed attempt %d is %+v", test.policy, test.script, n, rec)
+			}
+		}
+	}
+}
+
+// TestNewCmdRecord makes sure that only the start of stderr is kept,
+// and only for failures
+func TestNewCmdRecord(t *testing.T) {
+	stderr := bytes.Repeat([]byte("x"), maxResultStderr+100)
+	res := &CmdResult{Exe: "git", Params: []string{"add", "a"}, Stderr: stderr, ExitCode: 128,
+		Outcome: OutcomeError, Err: errors.New("git add failed")}
+	rec := newCmdRecord("add", res, 1)
+	if rec.Command != "git add" || rec.StderrBytes != len(stderr) || len(rec.Stderr) != maxResultStderr ||
+		rec.ExitCode != 128 || rec.Error != "git add failed" || rec.Attempt != 1 {
+		t.Errorf("failure record is %+v", rec)
+	}
+
+	res = &CmdResult{Exe: "git", Params: []string{"add", "a"}, Stderr: []byte("warning"), Outcome: OutcomeOK}
+	rec = newCmdRecord("add", res, 0)
+	if rec.StderrBytes != 7 || rec.Stderr != "" || rec.Error != "" || rec.Outcome != OutcomeOK {
+		t.Errorf("success record is %+v", rec)
+	}
+}
+
+// TestCommitOnError makes sure that a commit run stops at a failed
+// commit when aborting, and carries on past it when skipping
+func TestCommitOnError(t *testing.T) {
+	for _, policy := range []ErrorPolicy{ErrorAbort, ErrorSkip} {
+		dest, err := ioutil.TempDir("", "vcs-torture")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dest)
+
+		b := &fakeBackend{fail: map[int]bool{2: true}}
+		r := newFakeRepo(t, dest, b, 50, RepoOptions{NumCommits: 4, AddsPerCommit: 1, FilesPerAdd: 5, OnError: policy})
+		err = r.Commit(nil)
+
+		switch policy {
+		case ErrorAbort:
+			if err == nil || !strings.HasPrefix(err.Error(), "commit 2:") {
+				t.Errorf("abort: error %v, expected commit 2 to fail", err)
+			}
+			if b.attempts != 2 || b.commits != 1 {
+				t.Errorf("abort: %d commits of %d attempts, expected 1 of 2", b.commits, b.attempts)
+			}
+		case ErrorSkip:
+			if err != nil {
+				t.Errorf("skip: %s", err)
+			}
+			if b.attempts != 4 || b.commits != 3 {
+				t.Errorf("skip: %d commits of %d attempts, expected 3 of 4", b.commits, b.attempts)
+			}
+		}
+	}
+}
+
+// TestCommitInterrupted makes sure that an interrupted commit run
+// stops without committing, and leaves a checkpoint
+func TestCommitInterrupted(t *testing.T) {
+	dest, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	var signals gsos.CatchSignals
+	signals.Capture()
+	defer signals.Release()
+	p, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		err = p.Signal(os.Interrupt)
+	}
+	if err != nil {
+		t.Skipf("Can't interrupt this process: %s", err)
+	}
+	for i := 0; i < 100 && !signals.Aborted(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	b := &fakeBackend{}
+	r := newFakeRepo(t, dest, b, 50, RepoOptions{NumCommits: 4, AddsPerCommit: 1, FilesPerAdd: 5})
+	r.SetSignals(&signals)
+	err = r.Commit(nil)
+	if !errors.Is(err, ErrAborted) {
+		t.Errorf("Commit = %v, expected it to be interrupted", err)
+	}
+	if b.attempts != 0 {
+		t.Errorf("%d commits after an interrupt", b.attempts)
+	}
+	if _, err := r.readCheckpoint(); err != nil {
+		t.Errorf("No checkpoint: %s", err)
+	}
+}
@@ -0,0 +1,198 @@
+// vcs-torture/vcs/results.go
+
+package vcs
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// Result is one timed operation, written as a single line of JSON
+// (NDJSON) so that runs can be graphed without scraping the console.
+type Result struct {
+	RunID   string `json:"run_id"`
+	Vcs     string `json:"vcs"`
+	Op      string `json:"op"`
+	Command string `json:"command,omitempty"`
+	Seed    int64  `json:"seed,omitempty"`
+	Content string `json:"content,omitempty"`
+	Sizes   string `json:"sizes,omitempty"`
+	Layout  string `json:"layout,omitempty"`
+	Profile string `json:"profile,omitempty"`
+
+	// Where the run was when this operation happened
+	Commit     int `json:"commit"`
+	Files      int `json:"files"`
+	IndexFiles int `json:"index_files"`
+
+	// IndexBytes is the size of the files committed so far
+	IndexBytes int64 `json:"index_bytes,omitempty"`
+
+	// How many committed files are being changed, deleted and renamed,
+	// and how many directories moved, in this commit
+	Modified  int `json:"modified,omitempty"`
+	Deleted   int `json:"deleted,omitempty"`
+	Renamed   int `json:"renamed,omitempty"`
+	MovedDirs int `json:"moved_dirs,omitempty"`
+
+	// For a checkout (op=checkout), Commit is where it went to and
+	// Files is how many paths differ from where it came from
+	FromCommit int    `json:"from_commit,omitempty"`
+	Branch     string `json:"branch,omitempty"`
+
+	// For network operations, the protocol used (e.g. "http", "file")
+	Protocol string `json:"protocol,omitempty"`
+
+	// Network operations through a Proxy, and how it impaired them
+	// (latency and jitter in seconds, bandwidth in bytes per second)
+	Proxied   bool    `json:"proxied,omitempty"`
+	Latency   float64 `json:"latency,omitempty"`
+	Jitter    float64 `json:"jitter,omitempty"`
+	Bandwidth int64   `json:"bandwidth,omitempty"`
+	ChunkSize int     `json:"chunk_size,omitempty"`
+
+	// Time is seconds since the start of the program, Elapsed is
+	// how long this operation took
+	Time    float64 `json:"t"`
+	Elapsed float64 `json:"elapsed"`
+
+	// Once startup overhead has been measured (op=overhead), Corrected
+	// is Elapsed with the overhead taken off
+	Overhead     float64 `json:"overhead,omitempty"`
+	Corrected    float64 `json:"corrected,omitempty"`
+	OverheadMin  float64 `json:"overhead_min,omitempty"`
+	OverheadMax  float64 `json:"overhead_max,omitempty"`
+	OverheadRuns int     `json:"overhead_runs,omitempty"`
+
+	StdoutBytes int `json:"stdout_bytes"`
+	StderrBytes int `json:"stderr_bytes"`
+
+	// Resources used by the command (-1 where not measurable)
+	UserTime   float64 `json:"user_time,omitempty"`
+	SystemTime float64 `json:"sys_time,omitempty"`
+	MaxRSS     int64   `json:"max_rss,omitempty"`
+	ReadBytes  int64   `json:"read_bytes,omitempty"`
+	WriteBytes int64   `json:"write_bytes,omitempty"`
+	ReadChars  int64   `json:"read_chars,omitempty"`
+	WriteChars int64   `json:"write_chars,omitempty"`
+
+	// Outcome is "ok", "error", "timeout" or "memory"; failed commands
+	// also record the exit code, the error and (the start of) stderr
+	Outcome  string `json:"outcome,omitempty"`
+	Attempt  int    `json:"attempt,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+
+	// Repo storage, sampled during a commit run (op=stats)
+	StoreBytes   int64            `json:"store_bytes,omitempty"`
+	StoreFiles   int64            `json:"store_files,omitempty"`
+	LooseObjects int64            `json:"loose_objects,omitempty"`
+	PackObjects  int64            `json:"pack_objects,omitempty"`
+	Native       map[string]int64 `json:"native,omitempty"`
+
+	// A clone run (op=clone-summary): how long copying history and
+	// filling in the worktree took, and how big the clone is
+	Mode         string  `json:"mode,omitempty"`
+	CloneTime    float64 `json:"clone_time,omitempty"`
+	CheckoutTime float64 `json:"checkout_time,omitempty"`
+	DiskBytes    int64   `json:"disk_bytes,omitempty"`
+	DiskFiles    int64   `json:"disk_files,omitempty"`
+
+	// A network run (op=net-summary), as well as CloneTime
+	PushTime  float64 `json:"push_time,omitempty"`
+	FetchTime float64 `json:"fetch_time,omitempty"`
+
+	// Totals for a complete commit run (op=summary)
+	AddTime             float64 `json:"add_time,omitempty"`
+	ModifyTime          float64 `json:"modify_time,omitempty"`
+	MoveTime            float64 `json:"move_time,omitempty"`
+	CommitTime          float64 `json:"commit_time,omitempty"`
+	AddTimeCorrected    float64 `json:"add_time_corrected,omitempty"`
+	ModifyTimeCorrected float64 `json:"modify_time_corrected,omitempty"`
+	MoveTimeCorrected   float64 `json:"move_time_corrected,omitempty"`
+	CommitTimeCorrected float64 `json:"commit_time_corrected,omitempty"`
+}
+
+// maxResultStderr limits how much stderr is kept in a failure record
+const maxResultStderr = 4096
+
+// newCmdRecord makes a result for one run of an external command
+func newCmdRecord(op string, res *CmdResult, attempt int) *Result {
+	rec := &Result{
+		Op:          op,
+		Command:     res.Command(),
+		Elapsed:     res.Elapsed,
+		StdoutBytes: len(res.Stdout),
+		StderrBytes: len(res.Stderr),
+		Outcome:     res.Outcome,
+		Attempt:     attempt,
+
+		UserTime:   res.Usage.UserTime,
+		SystemTime: res.Usage.SystemTime,
+		MaxRSS:     res.Usage.MaxRSS,
+		ReadBytes:  res.Usage.ReadBytes,
+		WriteBytes: res.Usage.WriteBytes,
+		ReadChars:  res.Usage.ReadChars,
+		WriteChars: res.Usage.WriteChars,
+	}
+	if res.Err != nil {
+		rec.ExitCode = res.ExitCode
+		rec.Error = res.Err.Error()
+		stderr := res.Stderr
+		if len(stderr) > maxResultStderr {
+			stderr = stderr[:maxResultStderr]
+		}
+		rec.Stderr = string(stderr)
+	}
+	return rec
+}
+
+// Results is a stream of Result records. Each record is written
+// to the file immediately, so a run that dies part-way through
+// still leaves every completed operation behind.
+type Results struct {
+	RunID string
+
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// OpenResults opens (appending to) an NDJSON results file
+func OpenResults(path string, runID string) (*Results, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Results{RunID: runID, f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record writes one result. A nil Results discards everything, so
+// callers don't need to check whether results were asked for.
+func (s *Results) Record(res *Result) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res.RunID = s.RunID
+	return s.enc.Encode(res)
+}
+
+// Close flushes and closes the results file
+func (s *Results) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.f.Sync()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
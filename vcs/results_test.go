@@ -0,0 +1,128 @@
+// vcs-torture/vcs/results_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// readResults reads back an NDJSON results file, both as records and
+// as plain maps (to see which fields were written)
+func readResults(t *testing.T, path string) ([]Result, []map[string]interface{}) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Can't open results: %s", err)
+	}
+	defer f.Close()
+
+	var records []Result
+	var fields []map[string]interface{}
+	fs := bufio.NewScanner(f)
+	for fs.Scan() {
+		var rec Result
+		var m map[string]interface{}
+		if err := json.Unmarshal(fs.Bytes(), &rec); err != nil {
+			t.Fatalf("Bad results line %q: %s", fs.Text(), err)
+		}
+		json.Unmarshal(fs.Bytes(), &m)
+		records = append(records, rec)
+		fields = append(fields, m)
+	}
+	return records, fields
+}
+
+// TestResults makes sure that results are one JSON record per line,
+// appended to what is already there
+func TestResults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "results.ndjson")
+
+	for _, runID := range []string{"run1", "run2"} {
+		results, err := OpenResults(path, runID)
+		if err != nil {
+			t.Fatalf("OpenResults: %s", err)
+		}
+		if err := results.Record(&Result{Op: "add", Command: "git add", Elapsed: 1.5}); err != nil {
+			t.Fatalf("Record: %s", err)
+		}
+		if err := results.Record(&Result{Op: "commit", Commit: 1}); err != nil {
+			t.Fatalf("Record: %s", err)
+		}
+		if err := results.Close(); err != nil {
+			t.Fatalf("Close: %s", err)
+		}
+	}
+
+	records, fields := readResults(t, path)
+	if len(records) != 4 {
+		t.Fatalf("%d records, expected 4", len(records))
+	}
+	for i, want := range []string{"run1", "run1", "run2", "run2"} {
+		if records[i].RunID != want {
+			t.Errorf("record %d is from run %q, expected %q", i, records[i].RunID, want)
+		}
+	}
+	if records[0].Command != "git add" || records[0].Elapsed != 1.5 || records[1].Op != "commit" || records[1].Commit != 1 {
+		t.Errorf("records are %+v", records)
+	}
+
+	// Positions are always written, but most fields only when set
+	for _, name := range []string{"run_id", "vcs", "op", "commit", "files", "index_files", "t", "elapsed", "stdout_bytes", "stderr_bytes"} {
+		if _, ok := fields[1][name]; !ok {
+			t.Errorf("%s is missing from %v", name, fields[1])
+		}
+	}
+	for _, name := range []string{"command", "outcome", "error", "stderr", "max_rss"} {
+		if _, ok := fields[1][name]; ok {
+			t.Errorf("%s is in %v", name, fields[1])
+		}
+	}
+
+	var none *Results
+	if none.Record(&Result{Op: "add"}) != nil || none.Close() != nil {
+		t.Errorf("nil Results isn't a no-op")
+	}
+}
+
+// TestRecord makes sure that a repo fills in where the run is
+func TestRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "results.ndjson")
+
+	results, err := OpenResults(path, "run")
+	if err != nil {
+		t.Fatalf("OpenResults: %s", err)
+	}
+	r := NewRepo(dir, "repo", "git", time.Now(), RepoOptions{})
+	r.SetResults(results)
+	r.commit = 3
+	r.opFiles = 100
+	r.indexFiles = 250
+	r.record(&Result{Op: "add", Elapsed: 0.25})
+	results.Close()
+
+	records, _ := readResults(t, path)
+	if len(records) != 1 {
+		t.Fatalf("%d records, expected 1", len(records))
+	}
+	rec := records[0]
+	if rec.RunID != "run" || rec.Vcs != "git" || rec.Op != "add" || rec.Commit != 3 ||
+		rec.Files != 100 || rec.IndexFiles != 250 || rec.Elapsed != 0.25 || rec.Time <= 0 {
+		t.Errorf("record is %+v", rec)
+	}
+}
@@ -0,0 +1,134 @@
+// vcs-torture/vcs/server.go
+
+package vcs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"vcs-torture/gsos"
+)
+
+// Server is something serving a repo on localhost, started (and
+// stopped) by us. For the "file" protocol there is no server at all,
+// just a URL.
+type Server struct {
+	Protocol string
+	URL      string
+
+	// Addr is the host:port the server listens on
+	Addr string
+
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+	done   chan struct{}
+	http   *http.Server
+
+	// tempFiles are removed once the server has stopped
+	tempFiles []string
+}
+
+// serveTimeout is how long a server gets to start listening
+const serveTimeout = 10 * time.Second
+
+// Stop shuts the server down. Stopping a nil Server (or one for
+// the file protocol) does nothing.
+func (s *Server) Stop() error {
+	if s == nil {
+		return nil
+	}
+	var err error
+	if s.http != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), serveTimeout)
+		defer cancel()
+		err = s.http.Shutdown(ctx)
+	}
+	if s.cmd != nil {
+		gsos.KillProcessGroup(s.cmd.Process)
+		<-s.done
+	}
+	for _, path := range s.tempFiles {
+		os.Remove(path)
+	}
+	return err
+}
+
+// freePort finds a port on localhost that nothing is listening on.
+// Something else could take it before it's used, but that's unlikely.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// startServer runs a server program for s (in its own process group,
+// so that Stop takes out anything it starts too) that will listen on
+// port, and waits until it is accepting connections. If it fails, s is
+// stopped (so anything it was to clean up is cleaned up).
+func startServer(s *Server, protocol string, port int, dir string, exe string, params ...string) (*Server, error) {
+	exePath, err := lookupPath(exe)
+	if err != nil {
+		s.Stop()
+		return nil, err
+	}
+
+	s.Protocol, s.Addr = protocol, "127.0.0.1:"+strconv.Itoa(port)
+	s.cmd = exec.Command(exePath, params...)
+	s.cmd.Dir = dir
+	s.cmd.Stderr = &s.stderr
+	gsos.SetProcessGroup(s.cmd)
+	if err := s.cmd.Start(); err != nil {
+		s.cmd = nil
+		s.Stop()
+		return nil, err
+	}
+
+	var waitErr error
+	s.done = make(chan struct{})
+	go func() {
+		waitErr = s.cmd.Wait()
+		close(s.done)
+	}()
+
+	deadline := time.Now().Add(serveTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-s.done:
+			s.cmd = nil
+			s.Stop()
+			return nil, fmt.Errorf("%s exited before listening (%v): %s", exe, waitErr, bytes.TrimSpace(s.stderr.Bytes()))
+		default:
+		}
+		if conn, err := net.DialTimeout("tcp", s.Addr, time.Second); err == nil {
+			conn.Close()
+			return s, nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	s.Stop()
+	return nil, fmt.Errorf("%s didn't start listening on %s within %s", exe, s.Addr, serveTimeout)
+}
+
+// startHTTPServer serves handler on localhost from inside this process
+func startHTTPServer(protocol string, handler http.Handler) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{Protocol: protocol, Addr: l.Addr().String()}
+	s.http = &http.Server{Handler: handler}
+	go s.http.Serve(l)
+	return s, nil
+}
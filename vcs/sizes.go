@@ -0,0 +1,212 @@
+// vcs-torture/vcs/sizes.go
+
+package vcs
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Kinds of file size distribution
+const (
+	// SizeUniform is any size from Min to Max, equally likely
+	SizeUniform = "uniform"
+
+	// SizeLogNormal is a long-tailed distribution: half the files are
+	// smaller than Median, and Sigma says how spread out they are (the
+	// log of the size is normal, with standard deviation Sigma). Max
+	// (if not 0) caps it.
+	SizeLogNormal = "lognormal"
+
+	// SizeHistogram picks a bucket by its percentage, then any size in
+	// the bucket
+	SizeHistogram = "histogram"
+)
+
+// SizeBucket is one bar of a size histogram: Percent of the files are
+// from Min to Max bytes
+type SizeBucket struct {
+	Min     int64
+	Max     int64
+	Percent float64
+}
+
+// SizeDist says how big worktree files are
+type SizeDist struct {
+	Kind string
+
+	Min     int64
+	Max     int64
+	Median  int64
+	Sigma   float64
+	Buckets []SizeBucket
+
+	spec string
+}
+
+// ParseSize converts a size in bytes, with an optional K, M or G suffix
+// (powers of 1024); sizes can't be negative, or more than an int64 holds
+func ParseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("missing size")
+	}
+	digits := s
+	mult := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		digits = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("size '%s' is negative", s)
+	}
+	if n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("size '%s' is too big", s)
+	}
+	return n * mult, nil
+}
+
+// randBetween picks any number from min to max (0 <= min <= max)
+func randBetween(rng *rand.Rand, min int64, max int64) int64 {
+	if max-min == math.MaxInt64 {
+		return rng.Int63()
+	}
+	return min + rng.Int63n(max-min+1)
+}
+
+// parseSizeRange converts <min>-<max>
+func parseSizeRange(s string) (int64, int64, error) {
+	i := strings.IndexByte(s, '-')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("expected <min>-<max>, not '%s'", s)
+	}
+	min, err := ParseSize(s[:i])
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := ParseSize(s[i+1:])
+	if err != nil {
+		return 0, 0, err
+	}
+	if min < 0 || max < min {
+		return 0, 0, fmt.Errorf("bad size range '%s'", s)
+	}
+	return min, max, nil
+}
+
+// ParseSizeDist converts a size distribution, one of
+//
+//	uniform:<min>-<max>
+//	lognormal:<median>:<sigma>[:<max>]
+//	histogram:<min>-<max>:<pct>,<min>-<max>:<pct>...
+func ParseSizeDist(spec string) (*SizeDist, error) {
+	d := &SizeDist{spec: spec}
+	parts := strings.Split(spec, ":")
+	d.Kind = parts[0]
+
+	var err error
+	switch {
+	case d.Kind == SizeUniform && len(parts) == 2:
+		d.Min, d.Max, err = parseSizeRange(parts[1])
+
+	case d.Kind == SizeLogNormal && (len(parts) == 3 || len(parts) == 4):
+		if d.Median, err = ParseSize(parts[1]); err == nil && d.Median <= 0 {
+			err = fmt.Errorf("median must be more than 0")
+		}
+		if err == nil {
+			d.Sigma, err = strconv.ParseFloat(parts[2], 64)
+		}
+		if err == nil && !(d.Sigma >= 0 && d.Sigma <= 100) {
+			err = fmt.Errorf("sigma must be from 0 to 100")
+		}
+		if err == nil && len(parts) == 4 {
+			d.Max, err = ParseSize(parts[3])
+		}
+
+	case d.Kind == SizeHistogram && len(parts) >= 3:
+		total := 0.0
+		for _, bar := range strings.Split(strings.TrimPrefix(spec, SizeHistogram+":"), ",") {
+			i := strings.LastIndexByte(bar, ':')
+			if i < 0 {
+				return nil, fmt.Errorf("expected <min>-<max>:<pct>, not '%s'", bar)
+			}
+			var b SizeBucket
+			if b.Min, b.Max, err = parseSizeRange(bar[:i]); err != nil {
+				return nil, err
+			}
+			if b.Percent, err = strconv.ParseFloat(bar[i+1:], 64); err != nil || b.Percent < 0 {
+				return nil, fmt.Errorf("bad percentage in '%s'", bar)
+			}
+			d.Buckets = append(d.Buckets, b)
+			total += b.Percent
+		}
+		if total < 99.99 || total > 100.01 {
+			err = fmt.Errorf("histogram percentages add up to %g, not 100", total)
+		}
+
+	default:
+		err = fmt.Errorf("use uniform:<min>-<max>, lognormal:<median>:<sigma>[:<max>] or histogram:<min>-<max>:<pct>,...")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bad size distribution '%s': %s", spec, err)
+	}
+	return d, nil
+}
+
+// String is the distribution the way ParseSizeDist takes it
+func (d *SizeDist) String() string {
+	return d.spec
+}
+
+// sizeStream keeps the choice of sizes apart from other choices made
+// with the same seed
+const sizeStream = 0x51e5
+
+// sizeFor is the size of file nth; it depends only on the
+// distribution, the seed and nth
+func (d *SizeDist) sizeFor(seed int64, nth int) int64 {
+	rng := seededRand(seed^sizeStream, int64(nth))
+	between := func(min int64, max int64) int64 {
+		return randBetween(rng, min, max)
+	}
+
+	switch d.Kind {
+	case SizeUniform:
+		return between(d.Min, d.Max)
+
+	case SizeLogNormal:
+		size := float64(d.Median) * math.Exp(d.Sigma*rng.NormFloat64())
+		if d.Max > 0 && size > float64(d.Max) {
+			return d.Max
+		}
+		if size > math.MaxInt64/2 {
+			return math.MaxInt64 / 2
+		}
+		return int64(size)
+
+	default:
+		point := rng.Float64() * 100
+		for _, b := range d.Buckets {
+			if point < b.Percent {
+				return between(b.Min, b.Max)
+			}
+			point -= b.Percent
+		}
+		last := d.Buckets[len(d.Buckets)-1]
+		return between(last.Min, last.Max)
+	}
+}
@@ -0,0 +1,121 @@
+// vcs-torture/vcs/sizes_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"math"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"10000", 10000, true},
+		{"4K", 4 << 10, true},
+		{"4k", 4 << 10, true},
+		{"3M", 3 << 20, true},
+		{"2G", 2 << 30, true},
+		{"9223372036854775807", math.MaxInt64, true},
+		{"8589934591G", 8589934591 << 30, true},
+
+		{"", 0, false},
+		{"K", 0, false},
+		{"5X", 0, false},
+		{"1.5M", 0, false},
+		{"-1", 0, false},
+		{"-5M", 0, false},
+		{"8589934592G", 0, false},
+		{"99999999999G", 0, false},
+		{"99999999999999999999", 0, false},
+	}
+	for _, test := range tests {
+		got, err := ParseSize(test.in)
+		if test.ok && (err != nil || got != test.want) {
+			t.Errorf("ParseSize(%q) = %d, %v, expected %d", test.in, got, err, test.want)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("ParseSize(%q) = %d, expected an error", test.in, got)
+		}
+	}
+}
+
+func TestParseSizeDist(t *testing.T) {
+	tests := []struct {
+		in   string
+		want SizeDist
+		ok   bool
+	}{
+		{"uniform:1K-4K", SizeDist{Kind: SizeUniform, Min: 1 << 10, Max: 4 << 10}, true},
+		{"uniform:0-0", SizeDist{Kind: SizeUniform}, true},
+		{"lognormal:8K:1.5", SizeDist{Kind: SizeLogNormal, Median: 8 << 10, Sigma: 1.5}, true},
+		{"lognormal:8K:0:1M", SizeDist{Kind: SizeLogNormal, Median: 8 << 10, Max: 1 << 20}, true},
+		{"histogram:0-4K:70,4K-1M:29,1M-100M:1", SizeDist{Kind: SizeHistogram}, true},
+
+		{"", SizeDist{}, false},
+		{"normal:1-2", SizeDist{}, false},
+		{"uniform:5", SizeDist{}, false},
+		{"uniform:4K-1K", SizeDist{}, false},
+		{"uniform:-1-5", SizeDist{}, false},
+		{"uniform:1K-8589934592G", SizeDist{}, false},
+		{"lognormal:0:1", SizeDist{}, false},
+		{"lognormal:8K", SizeDist{}, false},
+		{"lognormal:8K:-1", SizeDist{}, false},
+		{"lognormal:8K:NaN", SizeDist{}, false},
+		{"lognormal:8K:1:-1M", SizeDist{}, false},
+		{"histogram:0-4K:70,4K-1M:20", SizeDist{}, false},
+		{"histogram:0-4K:-5,4K-1M:105", SizeDist{}, false},
+		{"histogram:0-4K", SizeDist{}, false},
+	}
+	for _, test := range tests {
+		got, err := ParseSizeDist(test.in)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("ParseSizeDist(%q) = %+v, expected an error", test.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSizeDist(%q): %s", test.in, err)
+			continue
+		}
+		if got.Kind != test.want.Kind || got.Min != test.want.Min || got.Max != test.want.Max ||
+			got.Median != test.want.Median || got.Sigma != test.want.Sigma || got.String() != test.in {
+			t.Errorf("ParseSizeDist(%q) = %+v, expected %+v", test.in, *got, test.want)
+		}
+	}
+
+	d, _ := ParseSizeDist("histogram:0-4K:70,4K-1M:29,1M-100M:1")
+	if len(d.Buckets) != 3 || d.Buckets[1].Min != 4<<10 || d.Buckets[1].Max != 1<<20 || d.Buckets[1].Percent != 29 {
+		t.Errorf("histogram buckets are %+v", d.Buckets)
+	}
+}
+
+// TestSizeFor makes sure that sizes are in range, and depend only on
+// the seed and the file
+func TestSizeFor(t *testing.T) {
+	for _, spec := range []string{
+		"uniform:1K-4K",
+		"uniform:0-9223372036854775807",
+		"lognormal:8K:3:1M",
+		"histogram:0-4K:70,4K-1M:29,1M-9223372036854775807:1",
+	} {
+		d, err := ParseSizeDist(spec)
+		if err != nil {
+			t.Fatalf("ParseSizeDist(%q): %s", spec, err)
+		}
+		for nth := 0; nth < 1000; nth++ {
+			size := d.sizeFor(42, nth)
+			if size < d.Min || d.Max > 0 && size > d.Max || size < 0 {
+				t.Errorf("%s: file %d is %d bytes", spec, nth, size)
+			}
+			if again := d.sizeFor(42, nth); again != size {
+				t.Errorf("%s: file %d is %d bytes, then %d", spec, nth, size, again)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// vcs-torture/vcs/stats.go
+
+package vcs
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"vcs-torture/gsos"
+)
+
+// StoreStats describes how much space a repo's history takes up
+type StoreStats struct {
+	// Size in bytes and number of files (inodes, really - directories
+	// count too) of everything under the backend's StoreDir
+	Bytes int64
+	Files int64
+
+	// LooseObjects and PackObjects are the backend's idea of
+	// individually stored and packed-together history (e.g. git loose
+	// and packed objects, svn unpacked and packed revisions)
+	LooseObjects int64
+	PackObjects  int64
+
+	// Native holds whatever else the version control system tells us
+	// about its storage (e.g. git count-objects -v)
+	Native map[string]int64
+}
+
+// storeStats measures the store of this repo
+func (r *Repo) storeStats() (*StoreStats, error) {
+	stats := &StoreStats{Native: make(map[string]int64)}
+
+	var err error
+	stats.Bytes, stats.Files, err = dirUsage(r.backend.StoreDir(r))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.backend.StoreStats(r, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// sampleStore measures the store and records it in the results and
+// the callback data. Sampling isn't included in any operation timings.
+func (r *Repo) sampleStore(cb *CommitCallbackData) error {
+	stats, err := r.storeStats()
+	if err != nil {
+		return err
+	}
+
+	cb.LooseObjects = int(stats.LooseObjects)
+	cb.PackObjects = int(stats.PackObjects)
+	r.record(&Result{
+		Op:           "stats",
+		StoreBytes:   stats.Bytes,
+		StoreFiles:   stats.Files,
+		LooseObjects: stats.LooseObjects,
+		PackObjects:  stats.PackObjects,
+		Native:       stats.Native,
+	})
+	return nil
+}
+
+// dirUsage returns the total size of all files in dir, and the number
+// of files and directories in it
+func dirUsage(dir string) (bytes int64, files int64, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		files++
+		if info.Mode().IsRegular() {
+			bytes += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// parseNativeStats pulls "name<sep> number" lines out of command output
+// into stats; names are lower-cased with spaces turned into dashes.
+// Lines that aren't numbers are ignored.
+func parseNativeStats(output []byte, sep string, stats map[string]int64) {
+	for _, line := range gsos.DataToLines(output) {
+		fields := strings.SplitN(line, sep, 2)
+		if len(fields) != 2 {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64)
+		if err != nil {
+			continue
+		}
+		name := strings.ToLower(strings.Join(strings.Fields(fields[0]), "-"))
+		stats[name] = n
+	}
+}
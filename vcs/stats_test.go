@@ -0,0 +1,113 @@
+// vcs-torture/vcs/stats_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func (*fakeBackend) StoreDir(r *Repo) string {
+	return r.repo
+}
+
+func (b *fakeBackend) StoreStats(r *Repo, stats *StoreStats) error {
+	stats.LooseObjects = int64(b.commits)
+	return nil
+}
+
+func TestParseNativeStats(t *testing.T) {
+	tests := []struct {
+		output string
+		sep    string
+		want   map[string]int64
+	}{
+		// git count-objects -v
+		{"count: 12\nsize: 48\nin-pack: 3000\npacks: 1\nsize-pack: 1024\nprune-packable: 0\ngarbage: 0\nsize-garbage: 0\n", ":",
+			map[string]int64{"count": 12, "size": 48, "in-pack": 3000, "packs": 1, "size-pack": 1024,
+				"prune-packable": 0, "garbage": 0, "size-garbage": 0}},
+
+		// svnadmin info, which has things that aren't numbers
+		{"Path: /tmp/repo-svnrepo\r\nUUID: 1d7c0a5e\r\nRepository Format: 5\r\nFilesystem Type: fsfs\r\nFSFS Shard Size: 1000\r\nFSFS Logical Addressing: yes\r\n", ":",
+			map[string]int64{"repository-format": 5, "fsfs-shard-size": 1000}},
+
+		// Other separators, and lines without them
+		{"revlogs=40\nno separator\nbad=1.5\n  spaced   out = -2\n", "=",
+			map[string]int64{"revlogs": 40, "spaced-out": -2}},
+		{"", ":", map[string]int64{}},
+	}
+	for _, test := range tests {
+		got := map[string]int64{}
+		parseNativeStats([]byte(test.output), test.sep, got)
+		if len(got) != len(test.want) {
+			t.Errorf("parseNativeStats(%q) = %v, expected %v", test.output, got, test.want)
+			continue
+		}
+		for name, n := range test.want {
+			if v, ok := got[name]; !ok || v != n {
+				t.Errorf("parseNativeStats(%q) = %v, expected %v", test.output, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestDirUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "a", "b"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "x"), make([]byte, 100), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "a", "b", "y"), make([]byte, 28), 0644)
+
+	bytes, files, err := dirUsage(dir)
+	if bytes != 128 || files != 5 || err != nil {
+		t.Errorf("dirUsage = %d bytes, %d files, %v, expected 128 bytes, 5 files", bytes, files, err)
+	}
+	if _, _, err := dirUsage(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("dirUsage of a missing directory worked")
+	}
+}
+
+// TestSampleEvery makes sure that the store is sampled every so many
+// commits, and once more at the end if that wasn't a sample
+func TestSampleEvery(t *testing.T) {
+	dest, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	path := filepath.Join(dest, "results.ndjson")
+	results, err := OpenResults(path, "run")
+	if err != nil {
+		t.Fatalf("OpenResults: %s", err)
+	}
+	b := &fakeBackend{}
+	r := newFakeRepo(t, dest, b, 50, RepoOptions{NumCommits: 5, AddsPerCommit: 1, FilesPerAdd: 5, SampleEvery: 2})
+	r.SetResults(results)
+	if err := r.Commit(nil); err != nil {
+		t.Fatalf("Commit: %s", err)
+	}
+	results.Close()
+
+	records, _ := readResults(t, path)
+	var sampled []int
+	for _, rec := range records {
+		if rec.Op == "stats" {
+			sampled = append(sampled, rec.Commit)
+			if rec.LooseObjects != int64(rec.Commit) || rec.StoreFiles == 0 {
+				t.Errorf("sample at commit %d is %+v", rec.Commit, rec)
+			}
+		}
+	}
+	if len(sampled) != 3 || sampled[0] != 2 || sampled[1] != 4 || sampled[2] != 5 {
+		t.Errorf("sampled at commits %v, expected [2 4 5]", sampled)
+	}
+}
@@ -2,14 +2,410 @@
 
 package vcs
 
-// Run a Subversion client command, returning elapsed time and stdout and stderr
-func RunSvnCommand(repodir string, env []string, cmd ...string) (float64, []byte, []byte) {
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"vcs-torture/gsos"
+)
+
+// Run a Subversion client command, returning elapsed time, stdout and stderr in a CmdResult
+func RunSvnCommand(repodir string, env []string, cmd ...string) (*CmdResult, error) {
 
 	return RunExternal("svn", repodir, env, cmd...)
 }
 
-// Run a Subversion admin command, returning elapsed time and stdout and stderr
-func RunSvnadminCommand(repodir string, env []string, cmd ...string) (float64, []byte, []byte) {
+// Run a Subversion admin command, returning elapsed time, stdout and stderr in a CmdResult
+func RunSvnadminCommand(repodir string, env []string, cmd ...string) (*CmdResult, error) {
 
 	return RunExternal("svnadmin", repodir, env, cmd...)
 }
+
+// svnBackend drives Subversion. The worktree at r.repo is a checkout
+// of trunk in a file:// repository that lives in a sibling directory;
+// branches are copies of trunk in branches/. Repos made before there
+// were branches have no trunk: the worktree is a checkout of the top,
+// and they can't be branched.
+type svnBackend struct{}
+
+func init() {
+	RegisterBackend(svnBackend{})
+}
+
+func (svnBackend) Name() string {
+	return "svn"
+}
+
+func (svnBackend) ServerDir(r *Repo) string {
+	return r.repo + "-svnrepo"
+}
+
+func (svnBackend) Init(r *Repo) error {
+	server := svnURL(r)
+
+	// "svnadmin create"
+	if _, err := r.run("init", "svnadmin", r.dest, "create", r.repoName+"-svnrepo"); err != nil {
+		return err
+	}
+
+	// "svn mkdir" the usual layout, so that branches can be copies of
+	// trunk (a directory can't be copied into itself)
+	if _, err := r.run("init", "svn", r.dest, "mkdir", "-m", "layout", server+"/trunk", server+"/branches"); err != nil {
+		return err
+	}
+
+	// "svn checkout" trunk
+	_, err := r.run("init", "svn", r.dest, "checkout", server+"/trunk", r.repoName)
+	return err
+}
+
+func (svnBackend) Remove(r *Repo) error {
+	return removeRepoDirs(r)
+}
+
+func (svnBackend) Add(r *Repo, files []string) (*CmdResult, error) {
+	return r.run("add", "svn", r.repo, append([]string{"add", "--parents"}, files...)...)
+}
+
+func (svnBackend) AddModified(r *Repo, files []string) (*CmdResult, error) {
+	// svn commit picks up changes to versioned files
+	return &CmdResult{Exe: "svn"}, nil
+}
+
+func (svnBackend) Delete(r *Repo, files []string) (*CmdResult, error) {
+	return r.run("delete", "svn", r.repo, append([]string{"rm", "-q", "--force"}, files...)...)
+}
+
+func (svnBackend) Move(r *Repo, from string, to string) (*CmdResult, error) {
+	// Commits leave the working copy at mixed revisions (what was
+	// committed is newer than its parent directories), and svn won't
+	// move a mixed-revision directory, so bring it all up to date first
+	if info, err := os.Stat(filepath.Join(r.repo, from)); err == nil && info.IsDir() {
+		if res, err := r.run("update", "svn", r.repo, "update", "-q"); err != nil {
+			return res, err
+		}
+	}
+	return r.run("move", "svn", r.repo, "mv", from, to)
+}
+
+func (svnBackend) Status(r *Repo) (*CmdResult, error) {
+	return r.run("status", "svn", r.repo, "status")
+}
+
+func (svnBackend) Commit(r *Repo, message string) (*CmdResult, error) {
+	return r.run("commit", "svn", r.repo, "commit", "-m", message)
+}
+
+func (svnBackend) HeadFiles(r *Repo) (int, error) {
+	// "svn list -R" on the server lists the youngest revision of trunk;
+	// directories are listed too (with a trailing slash), and aren't counted
+	trunk, err := svnTrunk(r)
+	if err != nil {
+		return 0, err
+	}
+	res, err := RunSvnCommand(r.dest, nil, "list", "-R", svnURL(r)+trunk)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, line := range gsos.DataToLines(res.Stdout) {
+		if line != "" && !strings.HasSuffix(line, "/") {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (svnBackend) NumCommits(r *Repo) (int, error) {
+	// Revision numbers are shared with the layout commit and with
+	// branches, so the youngest revision isn't the number of commits;
+	// count the revisions of trunk instead
+	revs, err := svnBackend{}.Revisions(r)
+	if err != nil {
+		return 0, err
+	}
+	return len(revs), nil
+}
+
+// svnURL is the file:// URL of the server repository
+func svnURL(r *Repo) string {
+	return fileURL(r.serverDir)
+}
+
+// svnTrunk is where the main line is in the server repository: /trunk,
+// or "" for a repo made before there were branches
+func svnTrunk(r *Repo) (string, error) {
+	res, err := RunSvnCommand(r.dest, nil, "list", svnURL(r))
+	if err != nil {
+		return "", err
+	}
+	top := map[string]bool{}
+	for _, line := range gsos.DataToLines(res.Stdout) {
+		top[line] = true
+	}
+	if top["trunk/"] && top["branches/"] {
+		return "/trunk", nil
+	}
+	return "", nil
+}
+
+// svnNeedBranches fails for a repo made before there were branches,
+// which has no trunk or branches to copy to
+func svnNeedBranches(r *Repo) error {
+	trunk, err := svnTrunk(r)
+	if err == nil && trunk == "" {
+		err = fmt.Errorf("svn: %s has no trunk and branches (it was made by an older vcs-torture); remove it and make it again to branch it", r.repo)
+	}
+	return err
+}
+
+// svnIsLayout says whether revision rev of the server repository did
+// nothing but make trunk and branches
+func svnIsLayout(r *Repo, rev string) (bool, error) {
+	// "svn log -v" lists changed paths as "   A /trunk"
+	res, err := RunSvnCommand(r.dest, nil, "log", "-q", "-v", "-r", rev, svnURL(r))
+	if err != nil {
+		return false, err
+	}
+	changes := 0
+	for _, line := range gsos.DataToLines(res.Stdout) {
+		if !strings.HasPrefix(line, "   ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[1] != "/trunk" && fields[1] != "/branches" {
+			return false, nil
+		}
+		changes++
+	}
+	return changes > 0, nil
+}
+
+func (svnBackend) VersionCommand() []string {
+	return []string{"svn", "--version"}
+}
+
+func (svnBackend) StoreDir(r *Repo) string {
+	return r.serverDir
+}
+
+func (svnBackend) StoreStats(r *Repo, stats *StoreStats) error {
+	// "svnadmin info" (Subversion 1.9 and later) describes the
+	// filesystem format and sharding; older versions don't have it,
+	// which isn't worth failing over
+	if res, err := RunSvnadminCommand(r.dest, nil, "info", r.serverDir); err == nil {
+		parseNativeStats(res.Stdout, ":", stats.Native)
+	}
+	shardSize := stats.Native["fsfs-shard-size"]
+	if shardSize == 0 {
+		shardSize = 1000
+	}
+
+	// Each revision is a file in db/revs/<shard>/, until a shard is
+	// packed into db/revs/<shard>.pack/
+	revs := filepath.Join(r.serverDir, "db", "revs")
+	shards, err := ioutil.ReadDir(revs)
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		if strings.HasSuffix(shard.Name(), ".pack") {
+			stats.Native["packed-shards"]++
+			stats.PackObjects += shardSize
+			continue
+		}
+		if !shard.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(revs, shard.Name()))
+		if err != nil {
+			return err
+		}
+		stats.LooseObjects += int64(len(files))
+	}
+	stats.Native["rev-files"] = stats.LooseObjects
+	return nil
+}
+
+func (svnBackend) Revisions(r *Repo) ([]string, error) {
+	// "svn log -q" lists revisions newest first, as "r12 | user | date"
+	// lines between separators
+	trunk, err := svnTrunk(r)
+	if err != nil {
+		return nil, err
+	}
+	res, err := RunSvnCommand(r.dest, nil, "log", "-q", svnURL(r)+trunk)
+	if err != nil {
+		return nil, err
+	}
+	var revs []string
+	for _, line := range gsos.DataToLines(res.Stdout) {
+		if !strings.HasPrefix(line, "r") {
+			continue
+		}
+		rev := strings.TrimSpace(strings.SplitN(line[1:], "|", 2)[0])
+		if _, err := strconv.Atoi(rev); err != nil {
+			return nil, fmt.Errorf("svn: unexpected log line %q", line)
+		}
+		revs = append(revs, rev)
+	}
+	for i, j := 0, len(revs)-1; i < j; i, j = i+1, j-1 {
+		revs[i], revs[j] = revs[j], revs[i]
+	}
+
+	// The revision that made trunk isn't one of ours
+	if trunk != "" && len(revs) > 0 {
+		layout, err := svnIsLayout(r, revs[0])
+		if err != nil {
+			return nil, err
+		}
+		if layout {
+			revs = revs[1:]
+		}
+	}
+	return revs, nil
+}
+
+func (svnBackend) Branch(r *Repo, name string, rev string) (float64, error) {
+	// A server-side copy, which doesn't touch the worktree
+	if err := svnNeedBranches(r); err != nil {
+		return 0, err
+	}
+	server := svnURL(r)
+	res, err := r.run("branch", "svn", r.dest, "copy", "-q", "-m", "branch "+name,
+		server+"/trunk@"+rev, server+"/branches/"+name)
+	return res.Elapsed, err
+}
+
+func (svnBackend) Branches(r *Repo) ([]string, error) {
+	if trunk, err := svnTrunk(r); err != nil || trunk == "" {
+		return nil, err
+	}
+	res, err := RunSvnCommand(r.dest, nil, "list", svnURL(r)+"/branches")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range gsos.DataToLines(res.Stdout) {
+		names = append(names, strings.TrimSuffix(line, "/"))
+	}
+	return names, nil
+}
+
+func (svnBackend) Checkout(r *Repo, branch string, rev string) (*CmdResult, error) {
+	trunk, err := svnTrunk(r)
+	if err != nil {
+		return &CmdResult{Exe: "svn"}, err
+	}
+	target := svnURL(r) + trunk
+	if branch != "" {
+		target = svnURL(r) + "/branches/" + branch
+	} else if rev != "" {
+		target += "@" + rev
+	}
+	return r.run("checkout", "svn", r.repo, "switch", "-q", target)
+}
+
+func (svnBackend) DiffFiles(r *Repo, from string, to string) (int, error) {
+	// Added directories are listed too
+	trunk, err := svnTrunk(r)
+	if err != nil {
+		return 0, err
+	}
+	trunk = svnURL(r) + trunk
+	res, err := RunSvnCommand(r.dest, nil, "diff", "--summarize", trunk+"@"+from, trunk+"@"+to)
+	if err != nil {
+		return 0, err
+	}
+	return len(gsos.DataToLines(res.Stdout)), nil
+}
+
+func (svnBackend) CloneModes() []string {
+	return []string{"checkout"}
+}
+
+func (svnBackend) Clone(r *Repo, dir string, mode string) (float64, float64, error) {
+	// A working copy only has the tip, so there is no history to copy
+	// separately; it's all checkout
+	trunk, err := svnTrunk(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	res, err := r.run("clone-checkout", "svn", r.dest, "checkout", "-q", svnURL(r)+trunk, filepath.Base(dir))
+	return 0, res.Elapsed, err
+}
+
+// Subversion is served by svnserve, which is given all of dest as its
+// root (so that the URL names the server directory, as for file://)
+func (svnBackend) ServeProtocols() []string {
+	return []string{"svn", "file"}
+}
+
+func (svnBackend) Serve(r *Repo, protocol string) (*Server, error) {
+	if protocol == "file" {
+		return &Server{Protocol: protocol, URL: svnURL(r)}, nil
+	}
+
+	// Anonymous users can only read by default; the configuration file
+	// lives outside dest, which is only for things we torture
+	conf, err := ioutil.TempFile("", "vcs-torture-svnserve-*.conf")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{tempFiles: []string{conf.Name()}}
+	_, err = conf.WriteString("[general]\nanon-access = write\n")
+	if cerr := conf.Close(); err == nil {
+		err = cerr
+	}
+	port, perr := freePort()
+	if err == nil {
+		err = perr
+	}
+	if err != nil {
+		s.Stop()
+		return nil, err
+	}
+
+	s, err = startServer(s, protocol, port, r.dest, "svnserve", "-d", "--foreground",
+		"--listen-host", "127.0.0.1", "--listen-port", strconv.Itoa(port),
+		"-r", ".", "--config-file", conf.Name())
+	if err != nil {
+		return nil, err
+	}
+	s.URL = "svn://" + s.Addr + "/" + filepath.Base(r.serverDir)
+	return s, nil
+}
+
+func (svnBackend) RemoteClone(r *Repo, url string, dir string) (*CmdResult, error) {
+	trunk, err := svnTrunk(r)
+	if err != nil {
+		return &CmdResult{Exe: "svn"}, err
+	}
+	return r.run("net-clone", "svn", r.dest, "checkout", "-q", url+trunk, filepath.Base(dir))
+}
+
+func (svnBackend) RemotePush(r *Repo, url string, dir string, branch string, path string) (*CmdResult, error) {
+	// A branch is made on the server, and the working copy switched to
+	// it; committing is what sends the files over
+	if err := svnNeedBranches(r); err != nil {
+		return &CmdResult{Exe: "svn"}, err
+	}
+	for _, params := range [][]string{
+		{"copy", "-q", "-m", "branch " + branch, url + "/trunk", url + "/branches/" + branch},
+		{"switch", "-q", url + "/branches/" + branch},
+		{"add", "-q", path},
+	} {
+		if res, err := r.run("net-commit", "svn", dir, params...); err != nil {
+			return res, err
+		}
+	}
+	return r.run("net-push", "svn", dir, "commit", "-q", "-m", "push "+branch)
+}
+
+func (svnBackend) RemoteFetch(r *Repo, url string, dir string, branch string) (*CmdResult, error) {
+	return r.run("net-fetch", "svn", dir, "switch", "-q", url+"/branches/"+branch)
+}
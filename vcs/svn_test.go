@@ -0,0 +1,103 @@
+// vcs-torture/vcs/svn_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TestSvnHeadFiles makes sure that files, not directories, are counted
+// in trunk, with a stand-in for svn
+func TestSvnHeadFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer fakeCommand(t, dir, "svn", `case "$*" in
+"list -R file://"*"/repo-svnrepo/trunk") printf 'a.txt\nd/\nd/b.txt\nd/e/\nd/e/c.txt\n' ;;
+"list file://"*"/repo-svnrepo") printf 'branches/\ntrunk/\n' ;;
+*) echo "svn: unexpected $*" >&2; exit 1 ;;
+esac
+`)()
+
+	r := NewRepo(dir, "repo", "svn", time.Now(), RepoOptions{})
+	if n, err := r.backend.HeadFiles(r); n != 3 || err != nil {
+		t.Errorf("HeadFiles = %d, %v, expected 3", n, err)
+	}
+}
+
+// fakeSvn stands in for svn on a server repository with revisions 1, 3
+// and 5 on its main line. Files in the current directory (dest) say
+// which kind: "flat" was made before there were branches, "mixed"
+// made trunk along with a file in revision 1; otherwise revision 1
+// only made trunk and branches.
+const fakeSvn = `top='branches/\ntrunk/\n'
+test -f flat && top='a.txt\nd/\n'
+r1='   A /branches\n   A /trunk\n'
+test -f flat && r1='   A /a.txt\n'
+test -f mixed && r1='   A /branches\n   A /trunk\n   A /trunk/a.txt\n'
+case "$*" in
+"list file://"*"/repo-svnrepo") printf "$top" ;;
+"list file://"*"/repo-svnrepo/branches") printf 'torture-3/\nother/\n' ;;
+"log -q file://"*"/repo-svnrepo/trunk") test -f flat && exit 1; printf -- '---\nr5 | u | d\n---\nr3 | u | d\n---\nr1 | u | d\n---\n' ;;
+"log -q file://"*"/repo-svnrepo") test -f flat || exit 1; printf -- '---\nr5 | u | d\n---\nr3 | u | d\n---\nr1 | u | d\n---\n' ;;
+"log -q -v -r 1 file://"*"/repo-svnrepo") printf -- "---\nr1 | u | d\nChanged paths:\n$r1---\n" ;;
+*) echo "svn: unexpected $*" >&2; exit 1 ;;
+esac
+`
+
+// TestSvnLayout makes sure that the revision that made trunk isn't
+// counted as a commit, and that repos without trunk still work (but
+// can't be branched)
+func TestSvnLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer fakeCommand(t, dir, "svn", fakeSvn)()
+
+	tests := []struct {
+		kind     string
+		revs     string
+		branches string
+	}{
+		{"", "3,5", "torture-3,other"},
+		{"mixed", "1,3,5", "torture-3,other"},
+		{"flat", "1,3,5", ""},
+	}
+	for _, test := range tests {
+		os.Remove(filepath.Join(dir, "flat"))
+		os.Remove(filepath.Join(dir, "mixed"))
+		if test.kind != "" {
+			ioutil.WriteFile(filepath.Join(dir, test.kind), nil, 0644)
+		}
+
+		r := NewRepo(dir, "repo", "svn", time.Now(), RepoOptions{})
+		revs, err := r.backend.Revisions(r)
+		if err != nil || strings.Join(revs, ",") != test.revs {
+			t.Errorf("%s: Revisions = %v, %v, expected %s", test.kind, revs, err, test.revs)
+		}
+		if n, err := r.backend.NumCommits(r); err != nil || n != len(revs) {
+			t.Errorf("%s: NumCommits = %d, %v", test.kind, n, err)
+		}
+		branches, err := r.backend.Branches(r)
+		if err != nil || strings.Join(branches, ",") != test.branches {
+			t.Errorf("%s: Branches = %v, %v, expected %s", test.kind, branches, err, test.branches)
+		}
+		if test.kind == "flat" {
+			if _, err := r.backend.Branch(r, "torture-1", "1"); err == nil || !strings.Contains(err.Error(), "no trunk") {
+				t.Errorf("%s: Branch = %v", test.kind, err)
+			}
+		}
+	}
+}
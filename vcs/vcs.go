@@ -4,62 +4,230 @@ package vcs
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
+	"time"
 
 	"vcs-torture/gsos"
 )
 
+// CmdResult is the outcome of running an external command
+type CmdResult struct {
+	Exe     string
+	Params  []string
+	Elapsed float64 // seconds
+	Stdout  []byte
+	Stderr  []byte
+
+	// ExitCode is the exit status of the command, or -1 if it
+	// couldn't be run at all (or was killed by a signal)
+	ExitCode int
+	Err      error
+
+	// Outcome is one of the Outcome constants
+	Outcome string
+
+	// Usage is the CPU, memory and I/O used by the command
+	Usage gsos.ProcessUsage
+}
+
+// Outcomes of a command. Timeout and memory mean the command hit one
+// of the CommandLimits, which is worth reporting differently from an
+// ordinary failure: it's a practical limit of the version control system.
+const (
+	OutcomeOK      = "ok"
+	OutcomeError   = "error"
+	OutcomeTimeout = "timeout"
+	OutcomeMemory  = "memory"
+)
+
+// ErrLimitExceeded is wrapped by the error for a command that
+// ran out of time or memory
+var ErrLimitExceeded = errors.New("operation exceeded practical limit")
+
+// CommandLimits bounds every external command; zero means no limit
+type CommandLimits struct {
+	// Timeout is the wall-clock limit; the command and everything
+	// it started are killed when it runs out
+	Timeout time.Duration
+
+	// Memory is the limit in bytes on the memory (data segment) of
+	// the command and anything it starts
+	Memory int64
+}
+
+var limits CommandLimits
+
+// SetCommandLimits sets the limits applied to every external command
+func SetCommandLimits(l CommandLimits) {
+	limits = l
+}
+
+// Command returns the executable and first parameter (e.g. "git add"),
+// which is usually enough to tell what was run without echoing an
+// entire file list
+func (res *CmdResult) Command() string {
+	if len(res.Params) == 0 {
+		return res.Exe
+	}
+	return res.Exe + " " + res.Params[0]
+}
+
 // RunExternal runs an external command, returning elapsed time in seconds, stdout and stderr.
 // This is a non-interactive version and is best used for commands that should
 // finish quickly (e.g in under 1 second). For interactive use or for feeding
 // commands stdin, use operateExternal
-func RunExternal(exe string, workingDir string, env []string, params ...string) (float64, []byte, []byte) {
+// The result is always returned, even if the command failed; a failure
+// is also returned as an error (and kept in the result)
+func RunExternal(exe string, workingDir string, env []string, params ...string) (*CmdResult, error) {
+	res := &CmdResult{Exe: exe, Params: params, ExitCode: -1}
 
 	// Do one-time find of the executable
-	exePath := lookupPath(exe)
+	exePath, err := lookupPath(exe)
+	if err != nil {
+		res.Err = err
+		res.Outcome = OutcomeError
+		return res, err
+	}
 
 	var stdout, stderr bytes.Buffer
 	cmdEnv := append(os.Environ(), env...)
 
-	c := exec.Command(exePath, params...)
+	c, ctx, cancel := limitedCommand(exePath, params...)
+	defer cancel()
 
 	c.Dir = workingDir
 	c.Env = cmdEnv
 	c.Stdout = &stdout
 	c.Stderr = &stderr
 
-	startTime := gsos.HighresTime()
-	err := c.Run()
-	cmdTime := (gsos.HighresTime() - startTime).Duration().Seconds() // TBD just return HighresTimestamp
+	res.Elapsed, res.Usage, err = runMeasured(c)
 
-	if err != nil {
-		log.Fatalf("\n%s %s failed: %s\nstdout: %s\nstderr: %s\n",
-			exe, strings.Join(params, " "), err, string(stdout.Bytes()), string(stderr.Bytes()))
+	res.Stdout = stdout.Bytes()
+	res.Stderr = stderr.Bytes()
+	var signal syscall.Signal
+	if c.ProcessState != nil {
+		res.ExitCode = c.ProcessState.ExitCode()
+		if ws, ok := c.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			signal = ws.Signal()
+		}
 	}
 
-	return cmdTime, stdout.Bytes(), stderr.Bytes()
+	res.Outcome = outcome(res, ctx, err, signal)
+	switch res.Outcome {
+	case OutcomeOK:
+		return res, nil
+	case OutcomeTimeout:
+		res.Err = fmt.Errorf("%s ran longer than %s: %w", res.Command(), limits.Timeout, ErrLimitExceeded)
+	case OutcomeMemory:
+		res.Err = fmt.Errorf("%s ran out of memory (limit %d bytes): %w", res.Command(), limits.Memory, ErrLimitExceeded)
+	default:
+		res.Err = fmt.Errorf("%s failed: %s", res.Command(), err)
+	}
+	return res, res.Err
+}
+
+// limitedCommand makes a command that runs in its own process group,
+// so that if it runs past limits.Timeout the context can kill it and
+// everything it started
+func limitedCommand(exePath string, params ...string) (*exec.Cmd, context.Context, context.CancelFunc) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if limits.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+	}
+
+	c := exec.CommandContext(ctx, exePath, params...)
+	gsos.SetProcessGroup(c)
+	c.Cancel = func() error {
+		return gsos.KillProcessGroup(c.Process)
+	}
+
+	// Don't wait forever for output from orphaned grandchildren
+	c.WaitDelay = 5 * time.Second
+
+	return c, ctx, cancel
+}
+
+// outcome works out how a command ended. Running out of memory doesn't
+// have a distinct exit status, so under a memory limit we go by what the
+// command said, or by it aborting (as C++ programs do when an allocation
+// fails). Being killed for taking too long, or crashing any other way,
+// isn't a memory failure.
+func outcome(res *CmdResult, ctx context.Context, err error, signal syscall.Signal) string {
+	if err == nil {
+		return OutcomeOK
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return OutcomeTimeout
+	}
+	if limits.Memory > 0 && (signal == syscall.SIGABRT || isOutOfMemory(res.Stderr)) {
+		return OutcomeMemory
+	}
+	return OutcomeError
+}
+
+// Things git, hg (Python) and svn (APR) say when an allocation fails
+var outOfMemoryMessages = []string{
+	"out of memory", "memoryerror", "cannot allocate memory", "allocation failed",
+}
+
+func isOutOfMemory(stderr []byte) bool {
+	text := strings.ToLower(string(stderr))
+	for _, msg := range outOfMemoryMessages {
+		if strings.Contains(text, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// runMeasured runs a command, returning elapsed time in seconds and the
+// resources it used. This is exec.Cmd.Run split in two so that we get
+// a chance to read I/O counters after the process exits but before it
+// is reaped. The memory limit is in place before the command starts.
+func runMeasured(c *exec.Cmd) (float64, gsos.ProcessUsage, error) {
+	var usage gsos.ProcessUsage
+
+	if limits.Memory > 0 {
+		if err := gsos.LimitMemory(c, limits.Memory); err != nil {
+			return 0, usage, fmt.Errorf("couldn't limit memory: %s", err)
+		}
+	}
+
+	startTime := gsos.HighresTime()
+	err := c.Start()
+	if err == nil {
+		io := gsos.WaitExited(c.Process)
+		err = c.Wait()
+		usage = gsos.ChildUsage(c.ProcessState, io)
+	}
+	elapsed := (gsos.HighresTime() - startTime).Duration().Seconds() // TBD just return HighresTimestamp
+
+	return elapsed, usage, err
 }
 
 // lookupPath memoizes executable paths for better performance - some
 // operating systems are slow to find executables. I suppose
 // it's unreasonable to expect exec.LookPath to do this...
-func lookupPath(exe string) string {
+func lookupPath(exe string) (string, error) {
 	exePath, ok := commandPaths[exe]
 	if ok {
-		return exePath
+		return exePath, nil
 	}
 
 	var err error
 	exePath, err = exec.LookPath(exe)
 	if err != nil {
-		log.Fatalf("Not installed: %s\n", exe)
+		return "", fmt.Errorf("Not installed: %s", exe)
 	}
 	commandPaths[exe] = exePath
-	return exePath
+	return exePath, nil
 }
 
 var commandPaths map[string]string = make(map[string]string)
@@ -76,12 +244,17 @@ type Command struct {
 	Stdout     bytes.Buffer
 	Stderr     bytes.Buffer
 	Elapsed    float64
+	Usage      gsos.ProcessUsage
 
 	cmd *exec.Cmd
 }
 
 func External(exe string, params ...string) *Command {
-	c := &Command{Exe: exe, ExePath: lookupPath(exe)}
+	exePath, err := lookupPath(exe)
+	if err != nil {
+		log.Fatalf("%s\n", err)
+	}
+	c := &Command{Exe: exe, ExePath: exePath}
 	c.Params = params
 	return c
 }
@@ -102,7 +275,8 @@ func (c *Command) SetEnv(env []string) *Command {
 }
 
 func (c *Command) RunNoFatal() error {
-	cmd := exec.Command(c.ExePath, c.Params...)
+	cmd, _, cancel := limitedCommand(c.ExePath, c.Params...)
+	defer cancel()
 
 	c.Stdin = bytes.Buffer{}
 	c.Stdout = bytes.Buffer{}
@@ -116,9 +290,8 @@ func (c *Command) RunNoFatal() error {
 	cmd.Stdout = &c.Stdout
 	cmd.Stderr = &c.Stderr
 
-	startTime := gsos.HighresTime()
-	err := cmd.Run()
-	c.Elapsed = (gsos.HighresTime() - startTime).Duration().Seconds()
+	var err error
+	c.Elapsed, c.Usage, err = runMeasured(cmd)
 	return err
 }
 
@@ -0,0 +1,91 @@
+// vcs-torture/vcs/vcs_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+func TestOutcome(t *testing.T) {
+	defer SetCommandLimits(CommandLimits{})
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	<-expired.Done()
+	failed := errors.New("failed")
+
+	tests := []struct {
+		memory int64
+		ctx    context.Context
+		err    error
+		signal syscall.Signal
+		stderr string
+		want   string
+	}{
+		{0, context.Background(), nil, 0, "", OutcomeOK},
+		{1 << 30, context.Background(), nil, 0, "out of memory", OutcomeOK},
+		{0, context.Background(), failed, 0, "", OutcomeError},
+		{0, expired, failed, syscall.SIGKILL, "", OutcomeTimeout},
+		{1 << 30, expired, failed, syscall.SIGKILL, "", OutcomeTimeout},
+		{1 << 30, context.Background(), failed, syscall.SIGABRT, "", OutcomeMemory},
+		{1 << 30, context.Background(), failed, 0, "fatal: Out of memory, malloc failed", OutcomeMemory},
+		{1 << 30, context.Background(), failed, 0, "MemoryError", OutcomeMemory},
+		{1 << 30, context.Background(), failed, syscall.SIGSEGV, "", OutcomeError},
+		{1 << 30, context.Background(), failed, 0, "fatal: not a git repository", OutcomeError},
+		{0, context.Background(), failed, syscall.SIGABRT, "out of memory", OutcomeError},
+	}
+	for _, test := range tests {
+		SetCommandLimits(CommandLimits{Memory: test.memory})
+		res := &CmdResult{Stderr: []byte(test.stderr)}
+		if got := outcome(res, test.ctx, test.err, test.signal); got != test.want {
+			t.Errorf("outcome(memory %d, %v, %v, %v, %q) = %s, expected %s",
+				test.memory, test.ctx.Err(), test.err, test.signal, test.stderr, got, test.want)
+		}
+	}
+}
+
+// TestTimeout makes sure that a command that runs too long is killed,
+// along with anything it started, and reported as a timeout
+func TestTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	dir, err := ioutil.TempDir("", "vcs-torture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	SetCommandLimits(CommandLimits{Timeout: 200 * time.Millisecond})
+	defer SetCommandLimits(CommandLimits{})
+
+	res, err := RunExternal("sh", dir, nil, "-c", "(sleep 1; touch late) & sleep 10")
+	if !errors.Is(err, ErrLimitExceeded) || res.Outcome != OutcomeTimeout {
+		t.Errorf("RunExternal = %s, %v, expected a timeout", res.Outcome, err)
+	}
+	if res.Elapsed > 4 {
+		t.Errorf("RunExternal took %.2fs to time out", res.Elapsed)
+	}
+
+	// Commands that finish in time aren't affected
+	if res, err := RunExternal("sh", dir, nil, "-c", "exit 0"); err != nil || res.Outcome != OutcomeOK {
+		t.Errorf("RunExternal = %s, %v, expected ok", res.Outcome, err)
+	}
+
+	if testing.Short() {
+		return
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(filepath.Join(dir, "late")); err == nil {
+		t.Errorf("a command's child outlived its timeout")
+	}
+}
@@ -3,13 +3,19 @@
 package vcs
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"hash/crc32"
+	"io"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+
+	"vcs-torture/gsos"
 )
 
 // Worktree manages the files that can be added to a repository
@@ -17,27 +23,65 @@ import (
 type Worktree struct {
 	WorktreeOptions
 
-	verbose		bool
-	root        string
+	verbose bool
+	dest    string
+	root    string
+	signals *gsos.CatchSignals
+
+	Files []string
+	dirs  map[string]int
+
+	// Moved lists files that have been renamed, moved or deleted ("")
+	// in the repo; they aren't made again
+	Moved map[string]string
 
-	nthUniqueName int
-	nthContent    int
+	// Written is how many bytes Generate wrote
+	Written int64
+	mu      sync.Mutex
+
+	// entries describes the files for the worktree manifest
+	entries         []WorktreeFile
+	manifestChanged bool
 
-	Files    []string
-	dirs     map[string]int
 	dirplace []int
+	pdirs    profileDirs
+	zipfCDF  []float64
 }
 
 type WorktreeOptions struct {
 	NumFiles    int
 	FilesPerDir int
 	DirsPerDir  int
-	FileSize    int
+	FileSize    int64
+
+	// Jobs is how many files Generate makes at once (0 is the same
+	// as 1)
+	Jobs int
+
+	// Content says what kind of content files have (all text if empty)
+	Content ContentMix
+
+	// Sizes says how big files are; if it's nil, they're all FileSize
+	Sizes *SizeDist
+
+	// Layout says how files are spread over directories (see Layouts)
+	Layout string
+
+	// Profile, if set, decides file names, places and sizes instead
+	// of Layout, FilesPerDir and DirsPerDir (and of FileSize, unless
+	// Sizes is set)
+	Profile *Profile
+
+	// Seed picks the content of the files (their names and places
+	// don't change). Content depends only on the seed and the file's
+	// index, so the same seed gives the same files anywhere; with a
+	// seed, lines always end in \n, even on Windows.
+	Seed int64
 }
 
 func NewWorktree(dest string, repo string, options WorktreeOptions) *Worktree {
 	root := filepath.Join(dest, repo)
-	w := &Worktree{root: root, WorktreeOptions: options}
+	w := &Worktree{dest: dest, root: root, WorktreeOptions: options}
 
 	// Set default values
 	if w.NumFiles == 0 {
@@ -52,6 +96,9 @@ func NewWorktree(dest string, repo string, options WorktreeOptions) *Worktree {
 	if w.FileSize == 0 {
 		w.FileSize = 10000
 	}
+	if w.Layout == "" {
+		w.Layout = LayoutBalanced
+	}
 
 	return w
 }
@@ -60,6 +107,11 @@ func (w *Worktree) SetVerbose(verbose bool) {
 	w.verbose = true
 }
 
+// SetSignals lets Generate be interrupted cleanly (between files)
+func (w *Worktree) SetSignals(signals *gsos.CatchSignals) {
+	w.signals = signals
+}
+
 type WorktreeCallbackData struct {
 	Done     bool
 	Pos      int
@@ -67,56 +119,234 @@ type WorktreeCallbackData struct {
 	Path     string
 }
 
+// Generate makes sure the worktree has NumFiles files in it, creating
+// any that are missing. Files listed in the worktree manifest are
+// assumed to be there; the others are looked for (and then listed),
+// Jobs at a time. Where files go doesn't depend on Jobs, and the
+// callback sees them in order, once they have been made. It returns
+// false if it was stopped early, either by the callback or by an
+// interrupt.
 func (w *Worktree) Generate(callback func(cb *WorktreeCallbackData) bool) bool {
+	if (w.Layout == LayoutZipf || w.Layout == LayoutMonorepo) && w.Profile == nil && w.DirsPerDir < 2 {
+		log.Fatalf("The %s layout needs 2 or more subdirectories per directory\n", w.Layout)
+	}
+
 	w.Files = make([]string, 0, w.NumFiles)
 	w.dirs = make(map[string]int)
+	w.Written = 0
 
 	var cb WorktreeCallbackData
 	cb.NumFiles = w.NumFiles
 
+	// If we're making the worktree from scratch, note that it's ours
+	if _, err := os.Stat(w.root); os.IsNotExist(err) {
+		if err := os.MkdirAll(w.root, os.ModePerm); err != nil {
+			log.Fatalf("\nCouldn't create %s: %s\n", w.root, err)
+		}
+		if err := recordOwned(w.dest, OwnedWorktree, "", w.root); err != nil {
+			log.Fatalf("\nCouldn't update %s: %s\n", ManifestName, err)
+		}
+	}
+
+	// Files that the worktree manifest lists are taken on trust
+	options, known, err := w.readManifest()
+	if err != nil {
+		fmt.Printf("Ignoring worktree manifest: %s\n", err)
+	}
+	if options != w.manifestOptions() {
+		known = nil
+	}
+	w.entries = known
+	w.manifestChanged = false
+
 	// Create our directory generator
 	w.dirplace = make([]int, 1, 6)
 	w.dirplace[0] = 0
+	w.pdirs = profileDirs{}
+	w.zipfCDF = nil
+
+	jobs := w.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	// Work through the files a batch at a time: first the paths (in
+	// order, making each new directory once), then the files (in
+	// parallel), then the callbacks (in order again)
+	for start := 0; start < w.NumFiles; {
+		end := start + jobs*generateBatch
+		if end > w.NumFiles {
+			end = w.NumFiles
+		}
 
-	// Create the paths where we will put files
-	for cb.Pos = 0; cb.Pos < w.NumFiles; cb.Pos++ {
-		fname := w.uniqueName()
-		dirpath := w.getDir()
+		var todo []int
+		for pos := start; pos < end; pos++ {
+			fname := w.fileName(pos)
+			dirpath := w.dirFor(pos)
 
-		// If we have a new dir, create it
-		if _, ok := w.dirs[dirpath]; !ok {
-			w.dirs[dirpath] = 1
-			os.MkdirAll(filepath.Join(w.root, dirpath), os.ModePerm)
+			// Build the path (dir + name)
+			path := fname
+			if dirpath != "" {
+				path = dirpath + "/" + fname
+			}
+			w.Files = append(w.Files, path)
+
+			// Make this file if it isn't in the manifest
+			if pos >= len(w.entries) || w.entries[pos].Path != path {
+				if _, ok := w.dirs[dirpath]; !ok {
+					w.dirs[dirpath] = 1
+					os.MkdirAll(filepath.Join(w.root, dirpath), os.ModePerm)
+				}
+				w.entries = append(w.entries[:pos], WorktreeFile{Path: path})
+				todo = append(todo, pos)
+			}
+		}
+		if len(todo) > 0 {
+			if made := w.makeFiles(todo, jobs); made < len(todo) {
+				// Interrupted: keep what's before the first file not made
+				end = todo[made]
+				w.Files, w.entries = w.Files[:end], w.entries[:end]
+			}
+			w.manifestChanged = true
 		}
 
-		// Build the path (dir + name)
-		cb.Path = fname
-		if dirpath != "" {
-			w.dirs[dirpath] = 1
-			cb.Path = dirpath + "/" + fname
+		for cb.Pos = start; cb.Pos < end; cb.Pos++ {
+			cb.Path = w.Files[cb.Pos]
+			if callback != nil && callback(&cb) {
+				w.Files = w.Files[:cb.Pos+1]
+				w.mustSaveManifest()
+				cb.Done = true
+				return !callback(&cb)
+			}
+		}
+		if w.signals.Aborted() {
+			w.mustSaveManifest()
+			cb.Done = true
+			if callback != nil {
+				callback(&cb)
+			}
+			return false
 		}
+		start = end
+	}
+
+	w.mustSaveManifest()
+	cb.Done = true
+	return callback == nil || !callback(&cb)
+}
 
-		// Make this file if it doesn't already exist
-		w.Files = append(w.Files, cb.Path)
+// TotalBytes is the size of all the worktree files (as made, or as last
+// changed by a commit run)
+func (w *Worktree) TotalBytes() int64 {
+	return w.bytesOf(0, len(w.Files))
+}
 
-		fpath := filepath.Join(w.root, cb.Path)
-		if _, err := os.Stat(fpath); err == nil {
-			w.nthContent += 1
-		} else {
-			content := w.makeContent(w.FileSize)
-			err := ioutil.WriteFile(fpath, content, os.ModePerm)
+// bytesOf is the size of worktree files from to up to
+func (w *Worktree) bytesOf(from int, to int) int64 {
+	var total int64
+	for i := from; i < to && i < len(w.entries); i++ {
+		total += w.entries[i].Size
+	}
+	return total
+}
+
+// generateBatch is how many files each job gets in a batch
+const generateBatch = 256
+
+// makeFiles makes the worktree files at positions todo, jobs at a time,
+// filling in their manifest entries. Once interrupted, it starts no
+// more files (and those in progress stop being written); it returns how
+// many of todo, from the first, were made.
+func (w *Worktree) makeFiles(todo []int, jobs int) int {
+	made := make([]bool, len(todo))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for j := 0; j < jobs && j < len(todo); j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if w.signals.Aborted() {
+					continue
+				}
+				pos := todo[i]
+				if file, ok := w.makeFile(pos, w.Files[pos]); ok {
+					w.entries[pos], made[i] = file, true
+				}
+			}
+		}()
+	}
+	for i := range todo {
+		if w.signals.Aborted() {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	n := 0
+	for n < len(made) && made[n] {
+		n++
+	}
+	return n
+}
+
+// makeFile makes worktree file pos if it isn't there already (and
+// hasn't been moved or deleted), and describes it for the manifest.
+// Its directory has already been made. If it's interrupted while
+// writing the file, it removes what it wrote and returns false.
+func (w *Worktree) makeFile(pos int, path string) (WorktreeFile, bool) {
+	fpath := filepath.Join(w.root, path)
+	if _, moved := w.Moved[path]; !moved {
+		if _, err := os.Stat(fpath); err != nil {
+			var size int64
+			hash, err := writeContentFile(fpath, func(out io.Writer) (err error) {
+				size, err = w.writeFileContent(abortWriter{out, w.signals}, pos)
+				return err
+			})
+			if errors.Is(err, ErrAborted) {
+				os.Remove(fpath)
+				return WorktreeFile{}, false
+			}
 			if err != nil {
 				log.Fatalf("\nCouldn't write %s: %s\n", fpath, err)
 			}
+			w.mu.Lock()
+			w.Written += size
+			w.mu.Unlock()
+			return WorktreeFile{Path: path, Size: size, Hash: hash}, true
 		}
+	}
 
-		if callback != nil && callback(&cb) {
-			break
-		}
+	file, err := w.describeFile(path)
+	if err != nil {
+		log.Fatalf("\nCouldn't read %s: %s\n", fpath, err)
 	}
+	return file, true
+}
 
-	cb.Done = true
-	return callback == nil || !callback(&cb)
+// abortWriter fails writes once a signal has been caught, so that a
+// big file stops being written a chunk into the interrupt
+type abortWriter struct {
+	out     io.Writer
+	signals *gsos.CatchSignals
+}
+
+func (a abortWriter) Write(p []byte) (int, error) {
+	if a.signals.Aborted() {
+		return 0, ErrAborted
+	}
+	return a.out.Write(p)
+}
+
+// mustSaveManifest saves the worktree manifest if it has changed
+func (w *Worktree) mustSaveManifest() {
+	if w.manifestChanged {
+		if err := w.saveManifest(); err != nil {
+			log.Fatalf("\nCouldn't write worktree manifest: %s\n", err)
+		}
+	}
 }
 
 // Make a unique name (encoding a unique number)
@@ -124,11 +354,7 @@ var stringAtoms []string = []string{
 	"at", "bi", "do", "ex", "fa", "go", "hi", "if", "ja", "ki", "lo", "me", "no", "of", "pi", "qi",
 }
 
-func (w *Worktree) uniqueName() string {
-	nth := w.nthUniqueName
-	w.nthUniqueName += 1
-
-	// Make a unique name
+func uniqueName(nth int) string {
 	var fragments []string
 	for nth >= 16 {
 		fragments = append(fragments, stringAtoms[nth%16])
@@ -179,43 +405,92 @@ var contentAtoms []string = []string{
 	"[", "]", "(", ")", "append", "copy", ":=", "==",
 }
 
-func (w *Worktree) makeContent(size int) []byte {
-	nth := w.nthContent
-	w.nthContent += 1
+// writeSeededContent writes the nth piece of content for a seed to out,
+// a chunk at a time. Seed 0 is the content there has always been, with
+// the platform's line endings.
+func writeSeededContent(out io.Writer, seed int64, nth int, size int64) error {
+	if seed == 0 {
+		return writeContent(out, nth, size, runtime.GOOS == "windows")
+	}
+	return writeContent(out, nth^int(mixSeed(seed)>>1), size, false)
+}
 
-	crlf := false
-	if runtime.GOOS == "windows" {
-		crlf = true
+// writeContentFile makes a new file with what write writes, returning
+// its hash
+func writeContentFile(path string, write func(out io.Writer) error) (uint32, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return 0, err
 	}
+	hash := crc32.New(crcTable)
+	err = write(io.MultiWriter(f, hash))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return hash.Sum32(), err
+}
 
-	content := make([]byte, size)
+// mixSeed spreads the bits of a seed around (this is SplitMix64's
+// finalizer), so that nearby seeds give unrelated content
+func mixSeed(seed int64) uint64 {
+	z := uint64(seed) + 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// seededRand is a random number generator for the nth choice of a run
+// with a seed
+func seededRand(seed int64, nth int64) *rand.Rand {
+	if seed != 0 {
+		nth ^= int64(mixSeed(seed))
+	}
+	return rand.New(rand.NewSource(nth))
+}
+
+// contentChunk is how much content writeContent makes at a time
+const contentChunk = 64 * 1024
+
+// writeContent writes the nth piece of content to out; the same nth and
+// size always give the same content. Lines are about 100 characters; the
+// end of a line replaces the space (or two) after its last word, so the
+// last two bytes made are held back until the next word.
+func writeContent(out io.Writer, nth int, size int64, crlf bool) error {
+	buf := make([]byte, 0, contentChunk)
 	col := 0
-	for i := 0; i < size; {
-		if col >= 100 && i < size {
+	for i := int64(0); i < size; {
+		if col >= 100 {
 			if crlf {
-				content[i-2] = '\r'
+				buf[len(buf)-2] = '\r'
 			}
-			content[i-1] = '\n'
+			buf[len(buf)-1] = '\n'
 			col = 0
 		}
-		var token []byte = []byte(contentAtoms[nth&31] + " ")
+		token := contentAtoms[nth&31] + " "
 		nth = ((nth << 27) | (nth >> 5)) + nth + 13
-		L := len(token)
+		L := int64(len(token))
 		if i+L > size {
 			L = size - i
 		}
-		copy(content[i:i+L], token[:L])
+		if len(buf)+int(L) > cap(buf) {
+			keep := len(buf) - 2
+			if _, err := out.Write(buf[:keep]); err != nil {
+				return err
+			}
+			buf = append(buf[:0], buf[keep:]...)
+		}
+		buf = append(buf, token[:L]...)
 		i += L
-		col += L
+		col += int(L)
 	}
 
-	if crlf {
-		content[size-2] = '\r'
+	if len(buf) == 0 {
+		return nil
 	}
-	content[size-1] = '\n'
-
-	if len(content) != size {
-		log.Fatalf("Oops: %s\n", string(content))
+	if crlf && len(buf) >= 2 {
+		buf[len(buf)-2] = '\r'
 	}
-	return content
+	buf[len(buf)-1] = '\n'
+	_, err := out.Write(buf)
+	return err
 }
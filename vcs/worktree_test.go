@@ -0,0 +1,89 @@
+// vcs-torture/vcs/worktree_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"bytes"
+	"runtime"
+)
+
+// contentInMemory is how content was made before it was streamed: the
+// whole file at once. Streamed content must match it byte for byte, or
+// worktrees made before stop verifying.
+func contentInMemory(nth int, size int, crlf bool) []byte {
+	content := make([]byte, size)
+	col := 0
+	for i := 0; i < size; {
+		if col >= 100 && i < size {
+			if crlf {
+				content[i-2] = '\r'
+			}
+			content[i-1] = '\n'
+			col = 0
+		}
+		var token []byte = []byte(contentAtoms[nth&31] + " ")
+		nth = ((nth << 27) | (nth >> 5)) + nth + 13
+		L := len(token)
+		if i+L > size {
+			L = size - i
+		}
+		copy(content[i:i+L], token[:L])
+		i += L
+		col += L
+	}
+
+	if crlf {
+		content[size-2] = '\r'
+	}
+	content[size-1] = '\n'
+	return content
+}
+
+// seededInMemory is seededContent as it was before content was streamed
+func seededInMemory(seed int64, nth int, size int) []byte {
+	if seed == 0 {
+		return contentInMemory(nth, size, runtime.GOOS == "windows")
+	}
+	return contentInMemory(nth^int(mixSeed(seed)>>1), size, false)
+}
+
+// contentSizes are sizes either side of a line and of a chunk
+var contentSizes = []int{2, 3, 99, 100, 101, 102, 1000, contentChunk - 1, contentChunk, contentChunk + 1, contentChunk + 2, 3*contentChunk + 57}
+
+// TestWriteContent makes sure that content streamed a chunk at a time
+// is the same as content made all at once
+func TestWriteContent(t *testing.T) {
+	for _, crlf := range []bool{false, true} {
+		for _, nth := range []int{0, 1, 31, 1000, 1 << 40} {
+			for _, size := range contentSizes {
+				var buf bytes.Buffer
+				if err := writeContent(&buf, nth, int64(size), crlf); err != nil {
+					t.Fatalf("writeContent(%d, %d, %t): %s", nth, size, crlf, err)
+				}
+				if !bytes.Equal(buf.Bytes(), contentInMemory(nth, size, crlf)) {
+					t.Errorf("writeContent(%d, %d, %t) doesn't match the content made in memory", nth, size, crlf)
+				}
+			}
+		}
+	}
+}
+
+// TestWriteSeededContent makes sure that a fixed seed streams the same
+// content as it always made
+func TestWriteSeededContent(t *testing.T) {
+	for _, seed := range []int64{0, 1, 42} {
+		for nth := 0; nth < 4; nth++ {
+			for _, size := range contentSizes {
+				var buf bytes.Buffer
+				if err := writeSeededContent(&buf, seed, nth, int64(size)); err != nil {
+					t.Fatalf("writeSeededContent(%d, %d, %d): %s", seed, nth, size, err)
+				}
+				if !bytes.Equal(buf.Bytes(), seededInMemory(seed, nth, size)) {
+					t.Errorf("writeSeededContent(%d, %d, %d) doesn't match the content made in memory", seed, nth, size)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// vcs-torture/vcs/wtmanifest.go
+
+package vcs
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A worktree manifest lists the files Generate has made, so that later
+// runs don't have to look at every one of them again (which takes a
+// long time with millions of files). It lives next to the repo in dest,
+// as text: a header line, a line of the options that decide where files
+// go, then a line per file of path, size, hash and revision, separated
+// by tabs.
+const worktreeManifestHeader = "vcs-torture worktree manifest 1"
+
+// WorktreeFile is what the worktree manifest knows about a file
+type WorktreeFile struct {
+	// Path is where Generate put the file (Worktree.Moved says where
+	// it is now)
+	Path string
+
+	// Size and Hash (CRC-32C) are of the file's current content
+	Size int64
+	Hash uint32
+
+	// Rev is the commit that last changed the file (0 if it's as it
+	// was made)
+	Rev int
+}
+
+// VerifyStats is what Verify found
+type VerifyStats struct {
+	Files   int
+	Deleted int
+
+	Missing   int
+	WrongSize int
+	WrongHash int
+
+	// Problems describes the first few bad files
+	Problems []string
+}
+
+// maxProblems is how many bad files Verify describes
+const maxProblems = 100
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func contentHash(content []byte) uint32 {
+	return crc32.Checksum(content, crcTable)
+}
+
+// hashFile reads a file (of any size), returning its size and hash
+func hashFile(path string) (int64, uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	hash := crc32.New(crcTable)
+	size, err := io.Copy(hash, f)
+	return size, hash.Sum32(), err
+}
+
+// worktreeManifestPath is where the worktree manifest for root is kept
+func worktreeManifestPath(root string) string {
+	return root + "-worktree.manifest"
+}
+
+// manifestOptions are the options that where files go depends on (and,
+// for files that haven't changed, their content)
+func (w *Worktree) manifestOptions() string {
+	options := fmt.Sprintf("files-per-dir=%d dirs-per-dir=%d size=%d seed=%d",
+		w.FilesPerDir, w.DirsPerDir, w.FileSize, w.Seed)
+	if len(w.Content) > 0 {
+		options += " content=" + w.Content.String()
+	}
+	if w.Sizes != nil {
+		options += " sizes=" + w.Sizes.String()
+	}
+	if w.Layout != LayoutBalanced {
+		options += " layout=" + w.Layout
+	}
+	if w.Profile != nil {
+		options += fmt.Sprintf(" profile=%08x", w.Profile.hash)
+	}
+	return options
+}
+
+// readManifest loads the worktree manifest, returning its options line
+// and files; if there isn't one, it returns no files and no error
+func (w *Worktree) readManifest() (string, []WorktreeFile, error) {
+	path := worktreeManifestPath(w.root)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	var options string
+	var files []WorktreeFile
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		switch line {
+		case 1:
+			if text != worktreeManifestHeader {
+				return "", nil, fmt.Errorf("%s: not a worktree manifest", path)
+			}
+		case 2:
+			options = text
+		default:
+			file, err := parseWorktreeFile(text)
+			if err != nil {
+				return "", nil, fmt.Errorf("%s:%d: %s", path, line, err)
+			}
+			files = append(files, file)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return options, files, nil
+}
+
+func parseWorktreeFile(line string) (WorktreeFile, error) {
+	var file WorktreeFile
+	fields := strings.Split(line, "\t")
+	if len(fields) != 4 {
+		return file, fmt.Errorf("expected 4 fields, found %d", len(fields))
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return file, err
+	}
+	hash, err := strconv.ParseUint(fields[2], 16, 32)
+	if err != nil {
+		return file, err
+	}
+	rev, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return file, err
+	}
+	file = WorktreeFile{Path: fields[0], Size: size, Hash: uint32(hash), Rev: rev}
+	return file, nil
+}
+
+// saveManifest writes the worktree manifest, replacing the old one
+// (the same way as checkpoints)
+func (w *Worktree) saveManifest() error {
+	path := worktreeManifestPath(w.root)
+	if err := recordOwned(w.dest, OwnedWorktreeManifest, "", path); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(f)
+	fmt.Fprintf(out, "%s\n%s\n", worktreeManifestHeader, w.manifestOptions())
+	for _, file := range w.entries {
+		fmt.Fprintf(out, "%s\t%d\t%08x\t%d\n", file.Path, file.Size, file.Hash, file.Rev)
+	}
+	err = out.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	w.manifestChanged = false
+	return os.Rename(path+".tmp", path)
+}
+
+// describeFile makes a manifest entry for a file from what's on disk
+func (w *Worktree) describeFile(path string) (WorktreeFile, error) {
+	file := WorktreeFile{Path: path}
+	where := path
+	if current, moved := w.Moved[path]; moved {
+		where = current
+	}
+	if where == "" {
+		return file, nil
+	}
+	var err error
+	file.Size, file.Hash, err = hashFile(filepath.Join(w.root, where))
+	return file, err
+}
+
+// noteEdit records that worktree file i is now size bytes with hash, as
+// of commit rev, returning how much bigger it is
+func (w *Worktree) noteEdit(i int, size int64, hash uint32, rev int) int64 {
+	if i >= len(w.entries) {
+		return 0
+	}
+	grown := size - w.entries[i].Size
+	w.entries[i].Size, w.entries[i].Hash, w.entries[i].Rev = size, hash, rev
+	w.manifestChanged = true
+	return grown
+}
+
+// Verify checks every file in the worktree manifest against the disk,
+// reading each one to check its hash. Files that have been moved are
+// looked for where they are now, and deleted ones are passed over.
+func (w *Worktree) Verify(callback func(cb *WorktreeCallbackData) bool) (*VerifyStats, error) {
+	options, files, err := w.readManifest()
+	if err != nil {
+		return nil, err
+	}
+	if options == "" {
+		return nil, fmt.Errorf("no worktree manifest for %s", w.root)
+	}
+
+	stats := &VerifyStats{}
+	problem := func(format string, args ...interface{}) {
+		if len(stats.Problems) < maxProblems {
+			stats.Problems = append(stats.Problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	cb := WorktreeCallbackData{NumFiles: len(files)}
+	for cb.Pos = 0; cb.Pos < len(files); cb.Pos++ {
+		file := files[cb.Pos]
+		cb.Path = file.Path
+		stats.Files++
+
+		where := file.Path
+		if current, moved := w.Moved[file.Path]; moved {
+			where = current
+		}
+		if where == "" {
+			stats.Deleted++
+		} else if size, hash, err := hashFile(filepath.Join(w.root, where)); err != nil {
+			stats.Missing++
+			problem("%s: %s", where, err)
+		} else if size != file.Size {
+			stats.WrongSize++
+			problem("%s: %d bytes, expected %d", where, size, file.Size)
+		} else if hash != file.Hash {
+			stats.WrongHash++
+			problem("%s: hash %08x, expected %08x", where, hash, file.Hash)
+		}
+
+		if callback != nil && callback(&cb) {
+			return stats, ErrStopped
+		}
+		if w.signals.Aborted() {
+			return stats, fmt.Errorf("%w after verifying %d of %d files", ErrAborted, stats.Files, len(files))
+		}
+	}
+
+	cb.Done = true
+	if callback != nil {
+		callback(&cb)
+	}
+	return stats, nil
+}
+
+// Bad is how many files didn't match the manifest
+func (s *VerifyStats) Bad() int {
+	return s.Missing + s.WrongSize + s.WrongHash
+}
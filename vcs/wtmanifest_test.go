@@ -0,0 +1,76 @@
+// vcs-torture/vcs/wtmanifest_test.go
+
+package vcs
+
+import (
+	"testing"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// TestWorktreeManifest makes a worktree, then makes sure that its
+// manifest is read back, and that Verify finds files that have changed
+func TestWorktreeManifest(t *testing.T) {
+	dest, err := ioutil.TempDir("", "worktree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	options := WorktreeOptions{NumFiles: 60, FilesPerDir: 8, DirsPerDir: 4, FileSize: 500, Jobs: 3, Seed: 42}
+	w := NewWorktree(dest, "repo", options)
+	if !w.Generate(nil) {
+		t.Fatal("Generate stopped early")
+	}
+	if w.Written != 60*500 {
+		t.Errorf("Generate wrote %d bytes, expected %d", w.Written, 60*500)
+	}
+
+	stats, err := w.Verify(nil)
+	if err != nil {
+		t.Fatalf("Verify: %s", err)
+	}
+	if stats.Files != 60 || stats.Bad() != 0 {
+		t.Errorf("Verify found %d bad files of %d, expected 0 of 60: %v", stats.Bad(), stats.Files, stats.Problems)
+	}
+
+	// The files in the manifest aren't made again
+	again := NewWorktree(dest, "repo", options)
+	if !again.Generate(nil) {
+		t.Fatal("Generate stopped early")
+	}
+	if again.Written != 0 {
+		t.Errorf("Generate wrote %d bytes for files in the manifest", again.Written)
+	}
+	if len(again.Files) != len(w.Files) || again.Files[59] != w.Files[59] {
+		t.Errorf("Generate put files somewhere else the second time")
+	}
+
+	// Change a byte of one file, cut another short and remove a third
+	path := func(i int) string { return filepath.Join(dest, "repo", w.Files[i]) }
+	content, err := ioutil.ReadFile(path(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[10] ^= 1
+	if err := ioutil.WriteFile(path(1), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path(2), 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err = w.Verify(nil)
+	if err != nil {
+		t.Fatalf("Verify: %s", err)
+	}
+	if stats.WrongHash != 1 || stats.WrongSize != 1 || stats.Missing != 1 || len(stats.Problems) != 3 {
+		t.Errorf("Verify found %d changed, %d cut short and %d missing, expected 1 of each: %v",
+			stats.WrongHash, stats.WrongSize, stats.Missing, stats.Problems)
+	}
+}